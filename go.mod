@@ -3,13 +3,15 @@ module github.com/stackrox/docker-registry-client
 go 1.19
 
 require (
+	github.com/distribution/reference v0.5.0
 	github.com/docker/distribution v2.8.3+incompatible
 	github.com/google/go-cmp v0.7.0
+	github.com/klauspost/compress v1.17.6
 	github.com/opencontainers/go-digest v1.0.0
+	sigs.k8s.io/yaml v1.4.0
 )
 
 require (
-	github.com/distribution/reference v0.5.0 // indirect
 	github.com/docker/libtrust v0.0.0-20160708172513-aabc10ec26b7 // indirect
 	github.com/gorilla/mux v1.8.0 // indirect
 	github.com/magefile/mage v1.10.0 // indirect
@@ -0,0 +1,308 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest"
+	"github.com/opencontainers/go-digest"
+)
+
+var (
+	// emptyJSON is the content of the MediaTypeEmptyJSON blob.
+	emptyJSON = []byte("{}")
+
+	// EmptyJSONDescriptor describes the empty JSON blob used as the config
+	// of artifacts which have none.
+	EmptyJSONDescriptor = distribution.Descriptor{
+		MediaType: MediaTypeEmptyJSON,
+		Size:      int64(len(emptyJSON)),
+		Digest:    digest.FromBytes(emptyJSON),
+	}
+)
+
+// ArtifactManifest is an OCI image manifest including the artifactType and
+// subject fields introduced by OCI image-spec v1.1, which
+// ocischema.Manifest lacks.
+type ArtifactManifest struct {
+	manifest.Versioned
+
+	// ArtifactType is the type of artifact held by the manifest.
+	ArtifactType string `json:"artifactType,omitempty"`
+
+	// Config references the configuration of the artifact as a blob.
+	Config distribution.Descriptor `json:"config"`
+
+	// Layers lists descriptors for the blobs making up the artifact.
+	Layers []distribution.Descriptor `json:"layers"`
+
+	// Subject references the manifest this artifact refers to.
+	Subject *distribution.Descriptor `json:"subject,omitempty"`
+
+	// Annotations contains arbitrary metadata for the manifest.
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// References returns the descriptors of the config and layers.
+func (m ArtifactManifest) References() []distribution.Descriptor {
+	references := make([]distribution.Descriptor, 0, 1+len(m.Layers))
+	references = append(references, m.Config)
+	references = append(references, m.Layers...)
+	return references
+}
+
+// DeserializedArtifactManifest wraps ArtifactManifest with the exact bytes it
+// was serialized to.
+type DeserializedArtifactManifest struct {
+	ArtifactManifest
+
+	canonical []byte
+}
+
+var _ distribution.Manifest = &DeserializedArtifactManifest{}
+
+// ArtifactManifestFromStruct marshals the given manifest and returns it along
+// with its JSON representation.
+func ArtifactManifestFromStruct(m ArtifactManifest) (*DeserializedArtifactManifest, error) {
+	canonical, err := json.MarshalIndent(&m, "", "   ")
+	if err != nil {
+		return nil, err
+	}
+	return &DeserializedArtifactManifest{
+		ArtifactManifest: m,
+		canonical:        canonical,
+	}, nil
+}
+
+// UnmarshalJSON populates the manifest from JSON data, keeping a copy of it.
+func (m *DeserializedArtifactManifest) UnmarshalJSON(b []byte) error {
+	m.canonical = make([]byte, len(b))
+	copy(m.canonical, b)
+
+	var artifact ArtifactManifest
+	if err := json.Unmarshal(m.canonical, &artifact); err != nil {
+		return err
+	}
+	if artifact.MediaType != "" && artifact.MediaType != MediaTypeImageManifest {
+		return fmt.Errorf("if present, mediaType in manifest should be '%s' not '%s'",
+			MediaTypeImageManifest, artifact.MediaType)
+	}
+	m.ArtifactManifest = artifact
+	return nil
+}
+
+// MarshalJSON returns the JSON representation of the manifest.
+func (m *DeserializedArtifactManifest) MarshalJSON() ([]byte, error) {
+	if len(m.canonical) > 0 {
+		return m.canonical, nil
+	}
+	return nil, errors.New("JSON representation not initialized in DeserializedArtifactManifest")
+}
+
+// Payload returns the media type and raw content of the manifest.
+func (m DeserializedArtifactManifest) Payload() (string, []byte, error) {
+	return MediaTypeImageManifest, m.canonical, nil
+}
+
+// RawManifest returns a manifest which pushes the given payload byte for byte
+// with the given media type, such as one returned by FetchManifestRaw, so that
+// it keeps its digest. Its references are read from the config, layers and
+// manifests fields of the payload, if it has them.
+func RawManifest(mediaType string, payload []byte) distribution.Manifest {
+	return rawManifest{mediaType: mediaType, payload: payload}
+}
+
+// rawManifest is a manifest of any type pushed exactly as given.
+type rawManifest struct {
+	mediaType string
+	payload   []byte
+}
+
+func (m rawManifest) References() []distribution.Descriptor {
+	var document manifestDocument
+	if err := json.Unmarshal(m.payload, &document); err != nil {
+		return nil
+	}
+	var references []distribution.Descriptor
+	if document.Config != nil {
+		references = append(references, *document.Config)
+	}
+	references = append(references, document.Layers...)
+	return append(references, document.Manifests...)
+}
+
+func (m rawManifest) Payload() (string, []byte, error) {
+	return m.mediaType, m.payload, nil
+}
+
+// ArtifactBlob is a blob to be pushed as a layer of an artifact.
+type ArtifactBlob struct {
+	MediaType   string
+	Annotations map[string]string
+	Content     []byte
+}
+
+// Artifact describes an OCI artifact, such as an SBOM or a scan report,
+// to be pushed with PushArtifact.
+type Artifact struct {
+	// ArtifactType is the type of the artifact. It is required unless a
+	// config with a media type of its own is given.
+	ArtifactType string
+	// ConfigMediaType and Config hold the config blob. If Config is nil, the
+	// empty JSON config is used; otherwise ConfigMediaType is required.
+	ConfigMediaType string
+	Config          []byte
+	// Blobs are pushed as the layers of the manifest. If there are none, a
+	// single empty JSON layer is used.
+	Blobs []ArtifactBlob
+	// Subject is the manifest the artifact refers to, if any.
+	Subject *distribution.Descriptor
+	// Annotations are set on the manifest.
+	Annotations map[string]string
+}
+
+// PushArtifact uploads the blobs of the artifact, assembles an OCI manifest
+// for it and pushes it as with PushArtifactManifest. An empty reference
+// pushes the manifest by digest only.
+func (registry *Registry) PushArtifact(repository, reference string, artifact Artifact) (PushedManifest, error) {
+	return registry.pushArtifact(repository, reference, artifact, nil)
+}
+
+// pushArtifact pushes an artifact whose blobs are followed by the given
+// layers, which must already have been uploaded.
+func (registry *Registry) pushArtifact(repository, reference string, artifact Artifact, uploaded []distribution.Descriptor) (PushedManifest, error) {
+	config := EmptyJSONDescriptor
+	configBlob := emptyJSON
+	if artifact.Config != nil {
+		if artifact.ConfigMediaType == "" {
+			return PushedManifest{}, errors.New("config media type is required when the artifact has a config")
+		}
+		configBlob = artifact.Config
+		config = distribution.Descriptor{
+			MediaType: artifact.ConfigMediaType,
+			Size:      int64(len(configBlob)),
+			Digest:    digest.FromBytes(configBlob),
+		}
+	}
+	if artifact.ArtifactType == "" && config.MediaType == MediaTypeEmptyJSON {
+		return PushedManifest{}, errors.New("artifact type is required when the artifact has no config")
+	}
+	if err := registry.uploadBlobIfMissing(repository, config.Digest, configBlob); err != nil {
+		return PushedManifest{}, err
+	}
+
+	layers := make([]distribution.Descriptor, 0, len(artifact.Blobs)+len(uploaded))
+	for _, blob := range artifact.Blobs {
+		descriptor := distribution.Descriptor{
+			MediaType:   blob.MediaType,
+			Size:        int64(len(blob.Content)),
+			Digest:      digest.FromBytes(blob.Content),
+			Annotations: blob.Annotations,
+		}
+		if err := registry.uploadBlobIfMissing(repository, descriptor.Digest, blob.Content); err != nil {
+			return PushedManifest{}, err
+		}
+		layers = append(layers, descriptor)
+	}
+	layers = append(layers, uploaded...)
+	if len(layers) == 0 {
+		if err := registry.uploadBlobIfMissing(repository, EmptyJSONDescriptor.Digest, emptyJSON); err != nil {
+			return PushedManifest{}, err
+		}
+		layers = append(layers, EmptyJSONDescriptor)
+	}
+
+	m, err := ArtifactManifestFromStruct(ArtifactManifest{
+		Versioned: manifest.Versioned{
+			SchemaVersion: 2,
+			MediaType:     MediaTypeImageManifest,
+		},
+		ArtifactType: artifact.ArtifactType,
+		Config:       config,
+		Layers:       layers,
+		Subject:      artifact.Subject,
+		Annotations:  artifact.Annotations,
+	})
+	if err != nil {
+		return PushedManifest{}, err
+	}
+	return registry.PushArtifactManifest(repository, reference, m)
+}
+
+// PushArtifactManifest pushes a manifest which may have a subject. An empty
+// reference pushes the manifest by digest only. If the registry does not
+// acknowledge the subject with an OCI-Subject header, it lacks the referrers
+// API and the manifest is added to the index stored under the ReferrersTag of
+// the subject instead, as the OCI distribution spec requires of clients.
+func (registry *Registry) PushArtifactManifest(repository, reference string, m *DeserializedArtifactManifest) (PushedManifest, error) {
+	descriptor, err := DescribeManifest(m)
+	if err != nil {
+		return PushedManifest{}, err
+	}
+	if reference == "" {
+		reference = descriptor.Digest.String()
+	}
+
+	pushed, err := registry.PutManifestWithDescriptor(repository, reference, m, PutManifestOptions{})
+	if err != nil {
+		return pushed, err
+	}
+	if m.Subject == nil || pushed.Subject != "" {
+		return pushed, nil
+	}
+
+	artifactType := m.ArtifactType
+	if artifactType == "" {
+		artifactType = m.Config.MediaType
+	}
+	descriptor.Annotations = m.Annotations
+	err = registry.addReferrerToTag(repository, m.Subject.Digest, ArtifactDescriptor{
+		Descriptor:   descriptor,
+		ArtifactType: artifactType,
+	})
+	return pushed, err
+}
+
+// addReferrerToTag adds a descriptor to the referrers index stored under the
+// ReferrersTag of the subject, creating the index if necessary.
+func (registry *Registry) addReferrerToTag(repository string, subject digest.Digest, referrer ArtifactDescriptor) error {
+	index, err := registry.referrersTagIndex(repository, subject)
+	if err != nil {
+		return err
+	}
+	if index == nil {
+		index = &referrersIndex{
+			Versioned: manifest.Versioned{
+				SchemaVersion: 2,
+				MediaType:     MediaTypeImageIndex,
+			},
+		}
+	}
+	for _, existing := range index.Manifests {
+		if existing.Digest == referrer.Digest {
+			return nil
+		}
+	}
+	index.Manifests = append(index.Manifests, referrer)
+
+	payload, err := json.MarshalIndent(index, "", "   ")
+	if err != nil {
+		return err
+	}
+	_, err = registry.PutManifestWithDescriptor(repository, ReferrersTag(subject), rawManifest{
+		mediaType: MediaTypeImageIndex,
+		payload:   payload,
+	}, PutManifestOptions{})
+	return err
+}
+
+func (registry *Registry) uploadBlobIfMissing(repository string, d digest.Digest, content []byte) error {
+	exists, err := registry.HasLayer(repository, d)
+	if err != nil || exists {
+		return err
+	}
+	return registry.UploadLayer(repository, d, bytes.NewReader(content))
+}
@@ -0,0 +1,69 @@
+package registry
+
+import (
+	"testing"
+)
+
+func TestPushArtifactWithSubject(t *testing.T) {
+	fake, r := newFakeRegistry(t)
+
+	image := pushTestImage(t, r, "test/image", `{"architecture":"amd64","os":"linux"}`)
+	subject := fake.descriptor(image)
+
+	pushed, err := r.PushArtifact("test/image", "", Artifact{
+		ArtifactType: "application/spdx+json",
+		Blobs:        []ArtifactBlob{{MediaType: "application/spdx+json", Content: []byte(`{"spdxVersion":"SPDX-2.3"}`)}},
+		Subject:      &subject,
+		Annotations:  map[string]string{"org.opencontainers.image.created": "2024-01-01T00:00:00Z"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.manifests[pushed.Digest]; !ok {
+		t.Fatalf("Expected artifact manifest %s to be pushed", pushed.Digest)
+	}
+	if _, ok := fake.blobs[EmptyJSONDescriptor.Digest]; !ok {
+		t.Error("Expected the empty config to be uploaded")
+	}
+
+	referrers, err := r.Referrers("test/image", image, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(referrers) != 1 || referrers[0].Digest != pushed.Digest || referrers[0].ArtifactType != "application/spdx+json" {
+		t.Errorf("Expected the artifact in the fallback referrers index but got: %+v", referrers)
+	}
+
+	// Pushing the same artifact again must not duplicate the index entry.
+	if _, err := r.PushArtifact("test/image", "", Artifact{
+		ArtifactType: "application/spdx+json",
+		Blobs:        []ArtifactBlob{{MediaType: "application/spdx+json", Content: []byte(`{"spdxVersion":"SPDX-2.3"}`)}},
+		Subject:      &subject,
+		Annotations:  map[string]string{"org.opencontainers.image.created": "2024-01-01T00:00:00Z"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if referrers, _ = r.Referrers("test/image", image, ""); len(referrers) != 1 {
+		t.Errorf("Expected a single referrer but got: %+v", referrers)
+	}
+
+	if _, err := r.PushArtifact("test/image", "", Artifact{}); err == nil {
+		t.Error("Expected an artifact without type or config to be rejected")
+	}
+}
+
+func TestPushArtifactRequiresConfigMediaType(t *testing.T) {
+	fake, r := newFakeRegistry(t)
+
+	for _, artifactType := range []string{"", "application/vnd.example.report"} {
+		if _, err := r.PushArtifact("test/image", "report", Artifact{
+			ArtifactType: artifactType,
+			Config:       []byte(`{"format":"v1"}`),
+		}); err == nil {
+			t.Errorf("Expected an error for a config without a media type and artifact type %q", artifactType)
+		}
+	}
+	if len(fake.manifests) != 0 {
+		t.Errorf("Expected nothing to be pushed but got %d manifests", len(fake.manifests))
+	}
+}
@@ -0,0 +1,285 @@
+package registry
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// MediaTypeDSSEEnvelope specifies the media type of DSSE envelopes.
+	MediaTypeDSSEEnvelope = "application/vnd.dsse.envelope.v1+json"
+	// MediaTypeInToto specifies the media type of unsigned in-toto statements.
+	MediaTypeInToto = "application/vnd.in-toto+json"
+	// ArtifactTypeCosignAttestation specifies the artifact type of cosign attestations stored as referrers.
+	ArtifactTypeCosignAttestation = "application/vnd.dev.cosign.artifact.att.v1+json"
+
+	// PredicateTypeSLSAProvenanceV02 specifies SLSA provenance v0.2 predicates.
+	PredicateTypeSLSAProvenanceV02 = "https://slsa.dev/provenance/v0.2"
+	// PredicateTypeSLSAProvenanceV1 specifies SLSA provenance v1 predicates.
+	PredicateTypeSLSAProvenanceV1 = "https://slsa.dev/provenance/v1"
+	// PredicateTypeSPDX specifies SPDX document predicates.
+	PredicateTypeSPDX = "https://spdx.dev/Document"
+	// PredicateTypeCycloneDX specifies CycloneDX BOM predicates.
+	PredicateTypeCycloneDX = "https://cyclonedx.org/bom"
+	// PredicateTypeVuln specifies cosign vulnerability scan predicates.
+	PredicateTypeVuln = "https://cosign.sigstore.dev/attestation/vuln/v1"
+
+	// annotationPredicateType is set by cosign on attestation layers.
+	annotationPredicateType = "predicateType"
+	// annotationInTotoPredicateType is set by BuildKit on attestation layers.
+	annotationInTotoPredicateType = "in-toto.io/predicate-type"
+)
+
+// DSSEEnvelope is a Dead Simple Signing Envelope.
+type DSSEEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     []byte          `json:"payload"`
+	Signatures  []DSSESignature `json:"signatures"`
+}
+
+// DSSESignature is a signature in a DSSEEnvelope.
+type DSSESignature struct {
+	KeyID string `json:"keyid"`
+	Sig   []byte `json:"sig"`
+}
+
+// PAE returns the pre-authentication encoding of the envelope payload,
+// which is what DSSE signatures are made over.
+func (e DSSEEnvelope) PAE() []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(e.PayloadType), e.PayloadType, len(e.Payload), e.Payload))
+}
+
+// InTotoStatement is an in-toto attestation statement.
+type InTotoStatement struct {
+	Type          string          `json:"_type"`
+	Subject       []InTotoSubject `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     json.RawMessage `json:"predicate"`
+}
+
+// InTotoSubject identifies an artifact an in-toto statement is about.
+type InTotoSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// SubjectDigests returns the digests of the statement's subjects.
+func (s InTotoStatement) SubjectDigests() []digest.Digest {
+	var digests []digest.Digest
+	for _, subject := range s.Subject {
+		for algorithm, encoded := range subject.Digest {
+			d := digest.NewDigestFromEncoded(digest.Algorithm(algorithm), encoded)
+			if d.Validate() == nil {
+				digests = append(digests, d)
+			}
+		}
+	}
+	return digests
+}
+
+// Attestation is an in-toto statement attached to an image.
+type Attestation struct {
+	// Manifest is the digest of the manifest the attestation was found in.
+	Manifest digest.Digest
+	// Layer describes the blob holding the attestation.
+	Layer distribution.Descriptor
+	// Envelope is the DSSE envelope the statement was signed in, or nil if
+	// the statement is unsigned.
+	Envelope *DSSEEnvelope
+	// Statement is the decoded in-toto statement.
+	Statement InTotoStatement
+}
+
+// Verify checks that the statement is about the given image digest and that
+// its envelope carries a signature by one of the given public keys. It does
+// not contact any server.
+func (a Attestation) Verify(d digest.Digest, keys ...crypto.PublicKey) error {
+	if !containsDigest(a.Statement.SubjectDigests(), d) {
+		return fmt.Errorf("attestation is not about %s", d)
+	}
+	if a.Envelope == nil {
+		return errors.New("attestation is not signed")
+	}
+	if len(keys) == 0 {
+		return errors.New("no public keys given")
+	}
+
+	pae := a.Envelope.PAE()
+	for _, signature := range a.Envelope.Signatures {
+		if verifySignature(pae, signature.Sig, keys) == nil {
+			return nil
+		}
+	}
+	return ErrSignatureInvalid
+}
+
+// VerifiedAttestations returns those attestations which Verify accepts for
+// the given digest and keys.
+func VerifiedAttestations(attestations []Attestation, d digest.Digest, keys ...crypto.PublicKey) []Attestation {
+	var verified []Attestation
+	for _, attestation := range attestations {
+		if attestation.Verify(d, keys...) == nil {
+			verified = append(verified, attestation)
+		}
+	}
+	return verified
+}
+
+// CosignAttestationTag returns the tag cosign stores the attestations of the given digest under.
+func CosignAttestationTag(d digest.Digest) string {
+	return fmt.Sprintf("%s-%s.att", d.Algorithm(), d.Encoded())
+}
+
+// Attestations returns the in-toto attestations of the given image digest,
+// found either under the cosign attestation tag or as referrers of the image.
+// If predicateTypes are given, only attestations with one of those predicate
+// types are returned. The attestations are not verified; use Verify to check
+// them. If a source or a single attestation fails, the attestations found
+// elsewhere are returned together with the first error.
+func (registry *Registry) Attestations(repository string, d digest.Digest, predicateTypes ...string) ([]Attestation, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid digest %v: %w", d, err)
+	}
+
+	referrers, referrersErr := registry.Referrers(repository, d, "")
+	attestations, err := registry.attestations(repository, d, referrers, predicateTypes)
+	if referrersErr != nil {
+		return attestations, referrersErr
+	}
+	return attestations, err
+}
+
+// attestations reads the attestations under the cosign attestation tag of
+// the given digest and those held by the given referrers of it, returning
+// what was found together with the first error.
+func (registry *Registry) attestations(repository string, d digest.Digest, referrers []ArtifactDescriptor, predicateTypes []string) ([]Attestation, error) {
+	var firstErr error
+	attestations, err := registry.attestationsFromManifest(repository, CosignAttestationTag(d), predicateTypes)
+	if err != nil && !isNotFound(err) {
+		firstErr = err
+	}
+	for _, referrer := range referrers {
+		switch referrer.ArtifactType {
+		case ArtifactTypeCosignAttestation, MediaTypeDSSEEnvelope, MediaTypeInToto:
+		default:
+			continue
+		}
+		found, err := registry.attestationsFromManifest(repository, referrer.Digest.String(), predicateTypes)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		attestations = append(attestations, found...)
+	}
+	return attestations, firstErr
+}
+
+func (registry *Registry) attestationsFromManifest(repository, reference string, predicateTypes []string) ([]Attestation, error) {
+	payload, _, err := registry.fetchManifest(repository, reference, MediaTypeImageManifest, schema2.MediaTypeManifest)
+	if err != nil {
+		return nil, err
+	}
+	var document manifestDocument
+	if err := json.Unmarshal(payload, &document); err != nil {
+		return nil, err
+	}
+	return registry.attestationsFromLayers(repository, digest.FromBytes(payload), document.Layers, predicateTypes)
+}
+
+// attestationsFromLayers downloads and decodes those layers which hold DSSE
+// envelopes or in-toto statements with one of the given predicate types. A
+// layer which cannot be read is skipped, and the first such error returned
+// along with the other attestations.
+func (registry *Registry) attestationsFromLayers(repository string, manifestDigest digest.Digest, layers []distribution.Descriptor, predicateTypes []string) ([]Attestation, error) {
+	var attestations []Attestation
+	var firstErr error
+	for _, layer := range layers {
+		if layer.MediaType != MediaTypeDSSEEnvelope && layer.MediaType != MediaTypeInToto {
+			continue
+		}
+		// Skip downloading layers whose predicate type is known not to match.
+		if annotated, ok := layerPredicateType(layer); ok && !matchesPredicateType(annotated, predicateTypes) {
+			continue
+		}
+
+		content, err := registry.fetchBlob(repository, layer)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		attestation, err := decodeAttestation(layer, content)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if !matchesPredicateType(attestation.Statement.PredicateType, predicateTypes) {
+			continue
+		}
+		attestation.Manifest = manifestDigest
+		attestations = append(attestations, attestation)
+	}
+	return attestations, firstErr
+}
+
+// decodeAttestation decodes a layer holding either a DSSE envelope or an
+// unsigned in-toto statement.
+func decodeAttestation(layer distribution.Descriptor, content []byte) (Attestation, error) {
+	attestation := Attestation{Layer: layer}
+
+	statement := content
+	if layer.MediaType == MediaTypeDSSEEnvelope {
+		var envelope DSSEEnvelope
+		if err := json.Unmarshal(content, &envelope); err != nil {
+			return Attestation{}, fmt.Errorf("invalid DSSE envelope %s: %w", layer.Digest, err)
+		}
+		if envelope.PayloadType != MediaTypeInToto {
+			return Attestation{}, fmt.Errorf("DSSE envelope %s has unexpected payload type %q", layer.Digest, envelope.PayloadType)
+		}
+		attestation.Envelope = &envelope
+		statement = envelope.Payload
+	}
+
+	if err := json.Unmarshal(statement, &attestation.Statement); err != nil {
+		return Attestation{}, fmt.Errorf("invalid in-toto statement %s: %w", layer.Digest, err)
+	}
+	return attestation, nil
+}
+
+// layerPredicateType returns the predicate type a layer is annotated with, if any.
+func layerPredicateType(layer distribution.Descriptor) (string, bool) {
+	if predicateType, ok := layer.Annotations[annotationPredicateType]; ok {
+		return predicateType, true
+	}
+	predicateType, ok := layer.Annotations[annotationInTotoPredicateType]
+	return predicateType, ok
+}
+
+func matchesPredicateType(predicateType string, predicateTypes []string) bool {
+	if len(predicateTypes) == 0 {
+		return true
+	}
+	for _, wanted := range predicateTypes {
+		if predicateType == wanted {
+			return true
+		}
+	}
+	return false
+}
+
+func containsDigest(digests []digest.Digest, d digest.Digest) bool {
+	for _, candidate := range digests {
+		if candidate == d {
+			return true
+		}
+	}
+	return false
+}
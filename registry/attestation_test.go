@@ -0,0 +1,87 @@
+package registry
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestAttestations(t *testing.T) {
+	fake, r := newFakeRegistry(t)
+	image := pushTestImage(t, r, "test/image", `{"architecture":"amd64","os":"linux"}`)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	envelope := func(predicateType string) []byte {
+		statement := fmt.Sprintf(`{"_type":"https://in-toto.io/Statement/v0.1","subject":[{"name":"test/image","digest":{"sha256":%q}}],"predicateType":%q,"predicate":{"builder":{"id":"ci"}}}`,
+			image.Encoded(), predicateType)
+		e := DSSEEnvelope{PayloadType: MediaTypeInToto, Payload: []byte(statement)}
+		hashed := sha256.Sum256(e.PAE())
+		sig, err := ecdsa.SignASN1(rand.Reader, key, hashed[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.Signatures = []DSSESignature{{Sig: sig}}
+		content, err := json.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return content
+	}
+
+	_, err = r.PushArtifact("test/image", CosignAttestationTag(image), Artifact{
+		ConfigMediaType: MediaTypeImageConfig,
+		Config:          []byte(`{}`),
+		Blobs: []ArtifactBlob{
+			{MediaType: MediaTypeDSSEEnvelope, Content: envelope(PredicateTypeSLSAProvenanceV02),
+				Annotations: map[string]string{annotationPredicateType: PredicateTypeSLSAProvenanceV02}},
+			{MediaType: MediaTypeDSSEEnvelope, Content: envelope(PredicateTypeSPDX)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attestations, err := r.Attestations("test/image", image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attestations) != 2 {
+		t.Fatalf("Expected 2 attestations but got: %d", len(attestations))
+	}
+
+	fake.referrersStatus = http.StatusInternalServerError
+	tagged, err := r.Attestations("test/image", image)
+	if err == nil {
+		t.Error("Expected the referrers error to be returned")
+	}
+	if len(tagged) != 2 {
+		t.Errorf("Expected the tagged attestations despite the referrers error but got: %d", len(tagged))
+	}
+	fake.referrersStatus = 0
+
+	attestations, err = r.Attestations("test/image", image, PredicateTypeSLSAProvenanceV02)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attestations) != 1 || attestations[0].Statement.PredicateType != PredicateTypeSLSAProvenanceV02 {
+		t.Fatalf("Expected only the provenance attestation but got: %+v", attestations)
+	}
+	if string(attestations[0].Statement.Predicate) != `{"builder":{"id":"ci"}}` {
+		t.Errorf("Unexpected predicate: %s", attestations[0].Statement.Predicate)
+	}
+	if err := attestations[0].Verify(image, &key.PublicKey); err != nil {
+		t.Errorf("Expected attestation to verify but got: %v", err)
+	}
+	if err := attestations[0].Verify(fakeDigest, &key.PublicKey); err == nil {
+		t.Error("Expected verification for another subject to fail")
+	}
+}
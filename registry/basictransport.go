@@ -13,7 +13,7 @@ type BasicTransport struct {
 }
 
 func (t *BasicTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	if strings.HasPrefix(req.URL.String(), t.URL) {
+	if strings.HasPrefix(req.URL.String(), t.URL) && !redirectedAcrossHosts(req) {
 		if t.Username != "" || t.Password != "" {
 			req.SetBasicAuth(t.Username, t.Password)
 		}
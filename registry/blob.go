@@ -0,0 +1,224 @@
+package registry
+
+import (
+	_ "crypto/sha512" // registers SHA-512 for verifying sha512 digests
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/docker/distribution"
+	"github.com/opencontainers/go-digest"
+)
+
+// URLAllowlist matches the external URLs DownloadBlob may fetch a blob from
+// when the registry does not serve it, such as those of foreign Windows
+// layers. Entries are either host names, which allow any https:// URL on that
+// host, or URL prefixes including the scheme.
+type URLAllowlist struct {
+	hosts    map[string]bool
+	prefixes []string
+}
+
+// ParseURLAllowlist builds a URLAllowlist from a list such as
+// []string{"mcr.microsoft.com", "https://go.microsoft.com/fwlink/"}.
+func ParseURLAllowlist(entries []string) (*URLAllowlist, error) {
+	allowlist := &URLAllowlist{
+		hosts: make(map[string]bool),
+	}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "://") {
+			allowlist.hosts[strings.ToLower(entry)] = true
+			continue
+		}
+		prefix, err := url.Parse(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed URL %q: %w", entry, err)
+		}
+		if prefix.Scheme != "http" && prefix.Scheme != "https" {
+			return nil, fmt.Errorf("invalid allowed URL %q: unsupported scheme %q", entry, prefix.Scheme)
+		}
+		if prefix.Path == "" {
+			prefix.Path = "/"
+		}
+		allowlist.prefixes = append(allowlist.prefixes, prefix.String())
+	}
+	return allowlist, nil
+}
+
+// Allows reports whether blobs may be downloaded from the given URL.
+func (a *URLAllowlist) Allows(u *url.URL) bool {
+	if a == nil || u.User != nil {
+		return false
+	}
+	if u.Scheme == "https" && a.hosts[strings.ToLower(u.Host)] {
+		return true
+	}
+	normalized := *u
+	normalized.Scheme = strings.ToLower(u.Scheme)
+	normalized.Host = strings.ToLower(u.Host)
+	if normalized.Path == "" {
+		normalized.Path = "/"
+	}
+	for _, prefix := range a.prefixes {
+		if strings.HasPrefix(normalized.String(), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// DownloadBlob downloads the blob described by descriptor. The registry is
+// asked first; if it reports the blob as not found, the descriptor's URLs which
+// Registry.BlobURLs allows are tried in order. External URLs are fetched
+// without the registry's credentials, and redirects from them are only
+// followed to URLs which are allowed as well.
+//
+// The returned reader verifies the size and digest of the content as it is
+// read: if they do not match the descriptor, the final Read returns an error
+// wrapping ErrDigestMismatch instead of io.EOF.
+func (registry *Registry) DownloadBlob(repository string, descriptor distribution.Descriptor) (io.ReadCloser, error) {
+	if err := descriptor.Digest.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid blob digest %v: %w", descriptor.Digest, err)
+	}
+
+	resp, err := registry.downloadLayer(repository, descriptor.Digest)
+	if err == nil {
+		// The size of the descriptor takes precedence over the Content-Length.
+		if descriptor.Size <= 0 {
+			descriptor.Size = resp.ContentLength
+		}
+		return newVerifyingReader(resp.Body, descriptor), nil
+	}
+	if !isNotFound(err) || len(descriptor.URLs) == 0 {
+		return nil, err
+	}
+
+	var urlErrs []string
+	for _, rawUrl := range descriptor.URLs {
+		blob, urlErr := registry.downloadBlobURL(rawUrl, descriptor.Digest)
+		if urlErr == nil {
+			return newVerifyingReader(blob, descriptor), nil
+		}
+		urlErrs = append(urlErrs, urlErr.Error())
+	}
+	return nil, fmt.Errorf("%w (descriptor URLs: %s)", err, strings.Join(urlErrs, "; "))
+}
+
+func (registry *Registry) downloadBlobURL(rawUrl string, d digest.Digest) (io.ReadCloser, error) {
+	blobUrl, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL for blob %s: %w", d, err)
+	}
+	if !registry.BlobURLs.Allows(blobUrl) {
+		return nil, fmt.Errorf("URL %s for blob %s is not allowed", blobUrl.Redacted(), d)
+	}
+
+	registry.Logf("registry.blob.download-url url=%s digest=%s", blobUrl.Redacted(), d)
+	client := &http.Client{
+		Transport:     registry.externalTransport(),
+		CheckRedirect: registry.checkBlobURLRedirect,
+	}
+	resp, err := client.Get(blobUrl.String())
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("got status %s fetching blob %s from %s", resp.Status, d, blobUrl.Redacted())
+	}
+	return resp.Body, nil
+}
+
+// checkBlobURLRedirect is the redirect policy for external blob URLs: every
+// hop must be allowed by Registry.BlobURLs and must not downgrade from HTTPS
+// to plain HTTP.
+func (registry *Registry) checkBlobURLRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	if via[len(via)-1].URL.Scheme == "https" && req.URL.Scheme != "https" {
+		return fmt.Errorf("refusing redirect from HTTPS to %s", req.URL.Redacted())
+	}
+	if !registry.BlobURLs.Allows(req.URL) {
+		return fmt.Errorf("redirect to %s is not allowed", req.URL.Redacted())
+	}
+	registry.Logf("registry.blob.redirect from=%s to=%s", redactURL(via[len(via)-1]), redactURL(req))
+	return nil
+}
+
+// externalTransport returns the transport underneath the authentication and
+// error handling transports of the registry, so that external URLs are
+// fetched with the same proxy and TLS settings but without credentials.
+func (registry *Registry) externalTransport() http.RoundTripper {
+	var transport http.RoundTripper = registry.Transport
+	if registry.Client != nil && registry.Client.Transport != nil {
+		transport = registry.Client.Transport
+	}
+	for {
+		switch t := transport.(type) {
+		case *ErrorTransport:
+			transport = t.Transport
+		case *BasicTransport:
+			transport = t.Transport
+		case *TokenTransport:
+			transport = t.Transport
+		default:
+			if transport == nil {
+				return http.DefaultTransport
+			}
+			return transport
+		}
+	}
+}
+
+// verifyingReader checks the size and digest of the content read through it
+// against a descriptor once the underlying reader is exhausted. A size of
+// zero or less is not checked. Once verification has failed, every further
+// Read returns the same error.
+type verifyingReader struct {
+	io.ReadCloser
+	descriptor distribution.Descriptor
+	hash       hash.Hash
+	read       int64
+	err        error
+}
+
+func newVerifyingReader(r io.ReadCloser, descriptor distribution.Descriptor) *verifyingReader {
+	return &verifyingReader{
+		ReadCloser: r,
+		descriptor: descriptor,
+		hash:       descriptor.Digest.Algorithm().Hash(),
+	}
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+	r.read += int64(n)
+	if size := r.descriptor.Size; size > 0 && r.read > size {
+		r.err = fmt.Errorf("%w: blob %s is larger than %d bytes", ErrSizeMismatch, r.descriptor.Digest, size)
+		return n, r.err
+	}
+	if err != io.EOF {
+		return n, err
+	}
+
+	if size := r.descriptor.Size; size > 0 && r.read != size {
+		r.err = fmt.Errorf("%w: blob %s has size %d, expected %d", ErrSizeMismatch, r.descriptor.Digest, r.read, size)
+	} else if actual := digest.NewDigest(r.descriptor.Digest.Algorithm(), r.hash); actual != r.descriptor.Digest {
+		r.err = fmt.Errorf("%w: blob %s has digest %s", ErrDigestMismatch, r.descriptor.Digest, actual)
+	} else {
+		r.err = io.EOF
+	}
+	return n, r.err
+}
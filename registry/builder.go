@@ -0,0 +1,229 @@
+package registry
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/ocischema"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/opencontainers/go-digest"
+)
+
+// ManifestBuilder assembles an OCI or Docker schema2 image manifest from a
+// config blob and a list of layers, uploads whichever blobs the repository
+// does not have yet and pushes the resulting manifest.
+//
+// Layers added from a reader are spooled to temporary files until the
+// builder is closed.
+type ManifestBuilder struct {
+	registry   *Registry
+	repository string
+	mediaType  string
+
+	config      distribution.Descriptor
+	configBlob  []byte
+	layers      []distribution.Descriptor
+	layerFiles  map[digest.Digest]string
+	annotations map[string]string
+}
+
+// NewManifestBuilder returns a builder for a manifest of the given media type,
+// which must be either MediaTypeImageManifest or schema2.MediaTypeManifest.
+func (registry *Registry) NewManifestBuilder(repository, mediaType string) (*ManifestBuilder, error) {
+	switch mediaType {
+	case MediaTypeImageManifest, schema2.MediaTypeManifest:
+	default:
+		return nil, fmt.Errorf("unsupported manifest media type %q", mediaType)
+	}
+
+	return &ManifestBuilder{
+		registry:   registry,
+		repository: repository,
+		mediaType:  mediaType,
+		layerFiles: make(map[digest.Digest]string),
+	}, nil
+}
+
+// SetConfig sets the config blob of the manifest. An empty mediaType selects
+// the image config media type matching the manifest format.
+func (b *ManifestBuilder) SetConfig(mediaType string, blob []byte) {
+	if mediaType == "" {
+		mediaType = b.defaultMediaType(MediaTypeImageConfig, schema2.MediaTypeImageConfig)
+	}
+	b.configBlob = blob
+	b.config = distribution.Descriptor{
+		MediaType: mediaType,
+		Size:      int64(len(blob)),
+		Digest:    digest.FromBytes(blob),
+	}
+}
+
+// AddLayer appends a layer which is already present in the repository.
+// An empty media type selects the gzip layer media type matching the manifest
+// format.
+func (b *ManifestBuilder) AddLayer(descriptor distribution.Descriptor) error {
+	if err := descriptor.Digest.Validate(); err != nil {
+		return fmt.Errorf("invalid layer digest %v: %w", descriptor.Digest, err)
+	}
+	if descriptor.MediaType == "" {
+		descriptor.MediaType = b.defaultMediaType(MediaTypeImageLayerGzip, schema2.MediaTypeLayer)
+	}
+	b.layers = append(b.layers, descriptor)
+	return nil
+}
+
+// AddLayerFromReader appends a layer whose content is read from the given
+// reader. The content is uploaded by Push if the repository does not have it.
+func (b *ManifestBuilder) AddLayerFromReader(mediaType string, content io.Reader) (distribution.Descriptor, error) {
+	file, err := os.CreateTemp("", "registry-layer-")
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	defer file.Close()
+
+	digester := digest.Canonical.Digester()
+	size, err := io.Copy(io.MultiWriter(file, digester.Hash()), content)
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return distribution.Descriptor{}, err
+	}
+
+	descriptor := distribution.Descriptor{
+		MediaType: mediaType,
+		Size:      size,
+		Digest:    digester.Digest(),
+	}
+	if existing, ok := b.layerFiles[descriptor.Digest]; ok {
+		_ = os.Remove(existing)
+	}
+	b.layerFiles[descriptor.Digest] = file.Name()
+
+	if err := b.AddLayer(descriptor); err != nil {
+		return distribution.Descriptor{}, err
+	}
+	return b.layers[len(b.layers)-1], nil
+}
+
+// SetAnnotation sets a manifest level annotation. Annotations are only
+// supported by OCI manifests.
+func (b *ManifestBuilder) SetAnnotation(key, value string) {
+	if b.annotations == nil {
+		b.annotations = make(map[string]string)
+	}
+	b.annotations[key] = value
+}
+
+// Build assembles the manifest without uploading anything.
+func (b *ManifestBuilder) Build() (distribution.Manifest, error) {
+	if b.config.Digest == "" {
+		return nil, errors.New("manifest config has not been set")
+	}
+
+	layers := make([]distribution.Descriptor, len(b.layers))
+	copy(layers, b.layers)
+
+	if b.mediaType == schema2.MediaTypeManifest {
+		if len(b.annotations) > 0 {
+			return nil, errors.New("annotations are not supported by Docker schema2 manifests")
+		}
+		return schema2.FromStruct(schema2.Manifest{
+			Versioned: schema2.SchemaVersion,
+			Config:    b.config,
+			Layers:    layers,
+		})
+	}
+
+	return ocischema.FromStruct(ocischema.Manifest{
+		Versioned:   ocischema.SchemaVersion,
+		Config:      b.config,
+		Layers:      layers,
+		Annotations: b.annotations,
+	})
+}
+
+// Push uploads the config and any layers missing from the repository, then
+// pushes the manifest under the given reference and returns its digest.
+func (b *ManifestBuilder) Push(reference string) (digest.Digest, error) {
+	manifest, err := b.Build()
+	if err != nil {
+		return "", err
+	}
+
+	if err := b.uploadConfig(); err != nil {
+		return "", err
+	}
+	for _, layer := range b.layers {
+		if err := b.uploadLayer(layer); err != nil {
+			return "", err
+		}
+	}
+
+	pushed, err := b.registry.PutManifestWithDescriptor(b.repository, reference, manifest, PutManifestOptions{})
+	if err != nil {
+		return "", err
+	}
+	return pushed.Digest, nil
+}
+
+// Close removes the temporary files holding layers added from readers.
+func (b *ManifestBuilder) Close() error {
+	var firstErr error
+	for d, name := range b.layerFiles {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) && firstErr == nil {
+			firstErr = err
+		}
+		delete(b.layerFiles, d)
+	}
+	return firstErr
+}
+
+func (b *ManifestBuilder) uploadConfig() error {
+	exists, err := b.registry.HasLayer(b.repository, b.config.Digest)
+	if err != nil || exists {
+		return err
+	}
+	return b.registry.UploadLayer(b.repository, b.config.Digest, bytes.NewReader(b.configBlob))
+}
+
+func (b *ManifestBuilder) uploadLayer(layer distribution.Descriptor) error {
+	if isForeignLayer(layer) {
+		return nil
+	}
+	exists, err := b.registry.HasLayer(b.repository, layer.Digest)
+	if err != nil || exists {
+		return err
+	}
+
+	name, ok := b.layerFiles[layer.Digest]
+	if !ok {
+		return fmt.Errorf("layer %s is missing from repository %s and no content was provided", layer.Digest, b.repository)
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return b.registry.UploadLayer(b.repository, layer.Digest, file)
+}
+
+// isForeignLayer reports whether a layer is foreign or non-distributable, such
+// as the base layers of Windows images. Registries are not expected to hold
+// such layers, which are fetched from the URLs in their descriptors instead.
+func isForeignLayer(layer distribution.Descriptor) bool {
+	return len(layer.URLs) > 0 ||
+		strings.Contains(layer.MediaType, ".foreign.") ||
+		strings.Contains(layer.MediaType, ".nondistributable.")
+}
+
+func (b *ManifestBuilder) defaultMediaType(oci, docker string) string {
+	if b.mediaType == schema2.MediaTypeManifest {
+		return docker
+	}
+	return oci
+}
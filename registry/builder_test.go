@@ -0,0 +1,111 @@
+package registry
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestManifestBuilderPush(t *testing.T) {
+	fake, r := newFakeRegistry(t)
+
+	b, err := r.NewManifestBuilder("test/image", MediaTypeImageManifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = b.Close() })
+
+	b.SetConfig("", []byte(`{"architecture":"amd64","os":"linux"}`))
+	layer, err := b.AddLayerFromReader("", strings.NewReader("layer content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.SetAnnotation("org.opencontainers.image.version", "1.0")
+
+	d, err := b.Push("latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if layer.MediaType != MediaTypeImageLayerGzip {
+		t.Errorf("Expected layer media type %q but got: %q", MediaTypeImageLayerGzip, layer.MediaType)
+	}
+	if _, ok := fake.blobs[layer.Digest]; !ok {
+		t.Errorf("Expected layer %s to be uploaded", layer.Digest)
+	}
+	if fake.tags["latest"] != d {
+		t.Errorf("Expected tag to point at %s but got: %s", d, fake.tags["latest"])
+	}
+
+	m, err := r.ManifestOCI("test/image", "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Annotations["org.opencontainers.image.version"] != "1.0" {
+		t.Errorf("Expected annotation to be pushed, got: %v", m.Annotations)
+	}
+	if m.Config.MediaType != MediaTypeImageConfig {
+		t.Errorf("Expected config media type %q but got: %q", MediaTypeImageConfig, m.Config.MediaType)
+	}
+}
+
+func TestManifestBuilderMissingLayer(t *testing.T) {
+	_, r := newFakeRegistry(t)
+
+	b, err := r.NewManifestBuilder("test/image", schema2.MediaTypeManifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.SetConfig("", []byte(`{}`))
+	if err := b.AddLayer(distribution.Descriptor{Digest: digest.FromString("missing"), Size: 7}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.Push("latest"); err == nil {
+		t.Error("Expected push to fail for a layer without content")
+	}
+
+	b.SetAnnotation("key", "value")
+	if _, err := b.Build(); err == nil {
+		t.Error("Expected annotations to be rejected for schema2 manifests")
+	}
+}
+
+func TestManifestBuilderForeignLayer(t *testing.T) {
+	_, r := newFakeRegistry(t)
+
+	b, err := r.NewManifestBuilder("test/windows", schema2.MediaTypeManifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.SetConfig("", []byte(`{"architecture":"amd64","os":"windows"}`))
+	foreign := distribution.Descriptor{
+		MediaType: schema2.MediaTypeForeignLayer,
+		Digest:    digest.FromString("windows base layer"),
+		Size:      1 << 30,
+		URLs:      []string{"https://mcr.microsoft.com/v2/windows/servercore/blobs/" + digest.FromString("windows base layer").String()},
+	}
+	if err := b.AddLayer(foreign); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.Push("latest"); err != nil {
+		t.Fatalf("Expected the foreign layer to be skipped but got: %v", err)
+	}
+
+	manifest, err := r.ManifestV2("test/windows", "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Layers) != 1 {
+		t.Fatalf("Expected 1 layer but got: %d", len(manifest.Layers))
+	}
+	layer := manifest.Layers[0]
+	if layer.MediaType != foreign.MediaType || layer.Digest != foreign.Digest || layer.Size != foreign.Size ||
+		len(layer.URLs) != 1 || layer.URLs[0] != foreign.URLs[0] {
+		t.Errorf("Expected the foreign layer unchanged but got: %+v", layer)
+	}
+}
@@ -0,0 +1,103 @@
+package registry
+
+import (
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// AnnotationDockerReferenceType is set by BuildKit on index entries which
+	// are not images, such as attestation manifests.
+	AnnotationDockerReferenceType = "vnd.docker.reference.type"
+	// AnnotationDockerReferenceDigest is set by BuildKit on attestation
+	// manifest entries to the digest of the image they describe.
+	AnnotationDockerReferenceDigest = "vnd.docker.reference.digest"
+	// DockerReferenceTypeAttestation is the AnnotationDockerReferenceType of
+	// attestation manifests.
+	DockerReferenceTypeAttestation = "attestation-manifest"
+)
+
+// AttestationManifest is an index entry holding BuildKit attestations.
+type AttestationManifest struct {
+	manifestlist.ManifestDescriptor
+
+	// Subject is the digest of the image manifest the attestations describe.
+	Subject digest.Digest
+}
+
+// IsAttestationManifest reports whether an index entry is a BuildKit
+// attestation manifest rather than an image. Such entries have the platform
+// unknown/unknown and must not be treated as runnable images.
+func IsAttestationManifest(entry manifestlist.ManifestDescriptor) bool {
+	return entry.Annotations[AnnotationDockerReferenceType] == DockerReferenceTypeAttestation
+}
+
+// SplitIndex separates the image manifests of an index from the BuildKit
+// attestation manifests, linking each attestation manifest to the image it
+// describes. Attestation manifests without a valid reference digest are
+// returned with an empty Subject.
+func SplitIndex(manifests []manifestlist.ManifestDescriptor) ([]manifestlist.ManifestDescriptor, []AttestationManifest) {
+	var images []manifestlist.ManifestDescriptor
+	var attestations []AttestationManifest
+	for _, entry := range manifests {
+		if !IsAttestationManifest(entry) {
+			images = append(images, entry)
+			continue
+		}
+		subject, err := digest.Parse(entry.Annotations[AnnotationDockerReferenceDigest])
+		if err != nil {
+			subject = ""
+		}
+		attestations = append(attestations, AttestationManifest{
+			ManifestDescriptor: entry,
+			Subject:            subject,
+		})
+	}
+	return images, attestations
+}
+
+// BuildKitAttestations fetches the in-toto statements held by a BuildKit
+// attestation manifest. If predicateTypes are given, only statements with one
+// of those predicate types are returned.
+func (registry *Registry) BuildKitAttestations(repository string, manifest AttestationManifest, predicateTypes ...string) ([]Attestation, error) {
+	return registry.attestationsFromManifest(repository, manifest.Digest.String(), predicateTypes)
+}
+
+// IndexAttestations fetches the index with the given reference and returns
+// the BuildKit attestations it contains, keyed by the digest of the image
+// manifest they describe. Attestation manifests which do not describe an
+// image of the index are ignored.
+func (registry *Registry) IndexAttestations(repository, reference string, predicateTypes ...string) (map[digest.Digest][]Attestation, error) {
+	index, err := registry.fetchIndex(repository, reference)
+	if err != nil {
+		return nil, err
+	}
+
+	attestations := make(map[digest.Digest][]Attestation)
+	for _, manifest := range indexAttestationManifests(index.Manifests) {
+		found, err := registry.BuildKitAttestations(repository, manifest, predicateTypes...)
+		if err != nil {
+			return nil, err
+		}
+		attestations[manifest.Subject] = append(attestations[manifest.Subject], found...)
+	}
+	return attestations, nil
+}
+
+// indexAttestationManifests returns the attestation manifests of an index
+// which describe one of the images in it.
+func indexAttestationManifests(manifests []manifestlist.ManifestDescriptor) []AttestationManifest {
+	images, attestations := SplitIndex(manifests)
+	inIndex := make(map[digest.Digest]bool)
+	for _, image := range images {
+		inIndex[image.Digest] = true
+	}
+
+	var described []AttestationManifest
+	for _, attestation := range attestations {
+		if inIndex[attestation.Subject] {
+			described = append(described, attestation)
+		}
+	}
+	return described
+}
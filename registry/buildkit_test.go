@@ -0,0 +1,120 @@
+package registry
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestSplitIndex(t *testing.T) {
+	image := digest.FromString("image")
+	manifests := []manifestlist.ManifestDescriptor{{
+		Descriptor: distribution.Descriptor{MediaType: MediaTypeImageManifest, Digest: image},
+		Platform:   manifestlist.PlatformSpec{Architecture: "amd64", OS: "linux"},
+	}, {
+		Descriptor: distribution.Descriptor{
+			MediaType: MediaTypeImageManifest,
+			Digest:    digest.FromString("attestation"),
+			Annotations: map[string]string{
+				AnnotationDockerReferenceType:   DockerReferenceTypeAttestation,
+				AnnotationDockerReferenceDigest: image.String(),
+			},
+		},
+		Platform: manifestlist.PlatformSpec{Architecture: "unknown", OS: "unknown"},
+	}}
+
+	images, attestations := SplitIndex(manifests)
+	if len(images) != 1 || images[0].Digest != image {
+		t.Errorf("Expected only the image manifest but got: %+v", images)
+	}
+	if len(attestations) != 1 || attestations[0].Subject != image {
+		t.Errorf("Expected the attestation manifest to be linked to %s but got: %+v", image, attestations)
+	}
+}
+
+// inTotoStatement returns an unsigned in-toto statement about subject.
+func inTotoStatement(subject digest.Digest, predicateType, predicate string) []byte {
+	return []byte(fmt.Sprintf(`{"_type":"https://in-toto.io/Statement/v0.1","subject":[{"name":"test/image","digest":{%q:%q}}],"predicateType":%q,"predicate":%s}`,
+		subject.Algorithm(), subject.Encoded(), predicateType, predicate))
+}
+
+// pushAttestationManifest pushes a BuildKit attestation manifest for subject
+// holding the given statements and returns the index entry referring to it.
+func pushAttestationManifest(t *testing.T, r *Registry, subject digest.Digest, statements ...[]byte) IndexEntry {
+	var blobs []ArtifactBlob
+	for _, statement := range statements {
+		blobs = append(blobs, ArtifactBlob{MediaType: MediaTypeInToto, Content: statement})
+	}
+	pushed, err := r.PushArtifact("test/image", "", Artifact{
+		ConfigMediaType: MediaTypeImageConfig,
+		Config:          []byte(`{"architecture":"unknown","os":"unknown"}`),
+		Blobs:           blobs,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return IndexEntry{
+		Digest:   pushed.Digest,
+		Platform: &manifestlist.PlatformSpec{Architecture: "unknown", OS: "unknown"},
+		Annotations: map[string]string{
+			AnnotationDockerReferenceType:   DockerReferenceTypeAttestation,
+			AnnotationDockerReferenceDigest: subject.String(),
+		},
+	}
+}
+
+func TestIndexAttestations(t *testing.T) {
+	_, r := newFakeRegistry(t)
+	amd64 := pushTestImage(t, r, "test/image", `{"architecture":"amd64","os":"linux"}`)
+	arm64 := pushTestImage(t, r, "test/image", `{"architecture":"arm64","os":"linux"}`)
+
+	_, err := r.PushIndex("test/image", "latest", MediaTypeImageIndex, []IndexEntry{
+		{Digest: amd64},
+		pushAttestationManifest(t, r, amd64,
+			inTotoStatement(amd64, PredicateTypeSLSAProvenanceV02, `{"builder":{"id":"ci"}}`),
+			inTotoStatement(amd64, PredicateTypeSPDX, `{"spdxVersion":"SPDX-2.3"}`)),
+		// The arm64 image is not part of the index.
+		pushAttestationManifest(t, r, arm64,
+			inTotoStatement(arm64, PredicateTypeSLSAProvenanceV02, `{"builder":{"id":"ci"}}`)),
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attestations, err := r.IndexAttestations("test/image", "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attestations) != 1 || len(attestations[amd64]) != 2 {
+		t.Fatalf("Expected 2 attestations for %s only but got: %v", amd64, attestations)
+	}
+
+	attestations, err = r.IndexAttestations("test/image", "latest", PredicateTypeSPDX)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attestations[amd64]) != 1 || attestations[amd64][0].Statement.PredicateType != PredicateTypeSPDX {
+		t.Errorf("Expected only the SPDX attestation but got: %+v", attestations[amd64])
+	}
+
+	index, err := r.ImageIndex("test/image", "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, manifests := SplitIndex(index.Manifests)
+	if len(manifests) != 2 {
+		t.Fatalf("Expected 2 attestation manifests but got: %d", len(manifests))
+	}
+	for _, manifest := range manifests {
+		found, err := r.BuildKitAttestations("test/image", manifest, PredicateTypeSLSAProvenanceV02)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(found) != 1 || found[0].Statement.Subject[0].Digest["sha256"] != manifest.Subject.Encoded() {
+			t.Errorf("Expected the provenance of %s but got: %+v", manifest.Subject, found)
+		}
+	}
+}
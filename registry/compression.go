@@ -0,0 +1,171 @@
+package registry
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/docker/distribution"
+	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// MediaTypeImageLayerZstd specifies the media type for a zstd compressed layer.
+	MediaTypeImageLayerZstd = "application/vnd.oci.image.layer.v1.tar+zstd"
+
+	// AnnotationEStargzTOCDigest holds the digest of the table of contents of an eStargz layer.
+	AnnotationEStargzTOCDigest = "containerd.io/snapshot/stargz/toc.digest"
+	// AnnotationEStargzUncompressedSize holds the uncompressed size of an eStargz layer.
+	AnnotationEStargzUncompressedSize = "io.containers.estargz.uncompressed-size"
+)
+
+// Compression is the compression algorithm of a layer.
+type Compression string
+
+const (
+	CompressionNone Compression = "none"
+	CompressionGzip Compression = "gzip"
+	CompressionZstd Compression = "zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// MediaTypeCompression returns the compression a layer media type declares.
+// It reports false for media types which are not known layer media types.
+func MediaTypeCompression(mediaType string) (Compression, bool) {
+	mediaType = strings.TrimSpace(strings.Split(mediaType, ";")[0])
+	switch {
+	case strings.HasSuffix(mediaType, "+gzip"), strings.HasSuffix(mediaType, ".tar.gzip"):
+		return CompressionGzip, true
+	case strings.HasSuffix(mediaType, "+zstd"), strings.HasSuffix(mediaType, ".tar.zstd"):
+		return CompressionZstd, true
+	case strings.HasSuffix(mediaType, ".tar"):
+		return CompressionNone, true
+	}
+	return "", false
+}
+
+// DetectCompression returns the compression indicated by the magic bytes at
+// the start of a layer. Anything which is neither gzip nor zstd is assumed
+// to be uncompressed.
+func DetectCompression(header []byte) Compression {
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return CompressionGzip
+	case bytes.HasPrefix(header, zstdMagic):
+		return CompressionZstd
+	}
+	return CompressionNone
+}
+
+// EStargz describes the eStargz annotations of a layer.
+type EStargz struct {
+	// TOCDigest is the digest of the uncompressed table of contents.
+	TOCDigest digest.Digest
+	// UncompressedSize is the size of the uncompressed layer, or -1 if it is
+	// not annotated.
+	UncompressedSize int64
+}
+
+// LayerEStargz returns the eStargz annotations of a layer descriptor, and
+// false if the layer is not annotated as eStargz. An eStargz layer is a
+// valid gzip layer, so it can also be read like any other.
+func LayerEStargz(descriptor distribution.Descriptor) (EStargz, bool) {
+	tocDigest, err := digest.Parse(descriptor.Annotations[AnnotationEStargzTOCDigest])
+	if err != nil {
+		return EStargz{}, false
+	}
+	estargz := EStargz{TOCDigest: tocDigest, UncompressedSize: -1}
+	if size, err := strconv.ParseInt(descriptor.Annotations[AnnotationEStargzUncompressedSize], 10, 64); err == nil {
+		estargz.UncompressedSize = size
+	}
+	return estargz, true
+}
+
+// DownloadLayerTar downloads the layer described by descriptor, as with
+// DownloadBlob, and returns its uncompressed tar stream together with the
+// compression it was stored with. The digest of the compressed content is
+// verified once the stream has been read to the end.
+func (registry *Registry) DownloadLayerTar(repository string, descriptor distribution.Descriptor) (io.ReadCloser, Compression, error) {
+	blob, err := registry.DownloadBlob(repository, descriptor)
+	if err != nil {
+		return nil, "", err
+	}
+	tar, compression, err := DecompressLayer(blob, descriptor.MediaType)
+	if err != nil {
+		blob.Close()
+		return nil, "", err
+	}
+	return tar, compression, nil
+}
+
+// DecompressLayer returns the uncompressed tar stream of a layer. The
+// compression is detected from the magic bytes of the content, which take
+// precedence over the one declared by mediaType since some tools push
+// uncompressed layers with compressed media types. Closing the returned
+// reader closes r.
+func DecompressLayer(r io.ReadCloser, mediaType string) (io.ReadCloser, Compression, error) {
+	buffered := bufio.NewReader(r)
+	header, err := buffered.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, "", err
+	}
+
+	compression := DetectCompression(header)
+	if declared, ok := MediaTypeCompression(mediaType); ok && declared != compression && compression != CompressionNone {
+		return nil, "", fmt.Errorf("layer of media type %s is %s compressed", mediaType, compression)
+	}
+
+	switch compression {
+	case CompressionGzip:
+		gzipReader, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, "", err
+		}
+		return &layerReader{Reader: gzipReader, close: gzipReader.Close, blob: r}, compression, nil
+	case CompressionZstd:
+		zstdReader, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, "", err
+		}
+		return &layerReader{Reader: zstdReader, close: func() error { zstdReader.Close(); return nil }, blob: r}, compression, nil
+	}
+	return &layerReader{Reader: buffered, blob: r}, compression, nil
+}
+
+// layerReader reads a decompressed layer. Once the decompressed stream ends,
+// the rest of the blob is drained so that a verifying blob reader gets to
+// check the digest.
+type layerReader struct {
+	io.Reader
+	close func() error
+	blob  io.ReadCloser
+}
+
+func (r *layerReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF {
+		if _, drainErr := io.Copy(io.Discard, r.blob); drainErr != nil {
+			return n, drainErr
+		}
+	}
+	return n, err
+}
+
+func (r *layerReader) Close() error {
+	if r.close != nil {
+		if err := r.close(); err != nil {
+			r.blob.Close()
+			return err
+		}
+	}
+	return r.blob.Close()
+}
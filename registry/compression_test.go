@@ -0,0 +1,100 @@
+package registry
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestMediaTypeCompression(t *testing.T) {
+	for mediaType, expected := range map[string]Compression{
+		MediaTypeImageLayer:                     CompressionNone,
+		MediaTypeImageLayerGzip:                 CompressionGzip,
+		MediaTypeImageLayerZstd:                 CompressionZstd,
+		MediaTypeImageLayerNonDistributableGzip: CompressionGzip,
+		schema2.MediaTypeLayer:                  CompressionGzip,
+		schema2.MediaTypeForeignLayer:           CompressionGzip,
+		schema2.MediaTypeUncompressedLayer:      CompressionNone,
+	} {
+		if actual, ok := MediaTypeCompression(mediaType); !ok || actual != expected {
+			t.Errorf("Expected %s for %s but got: %s", expected, mediaType, actual)
+		}
+	}
+	if _, ok := MediaTypeCompression(MediaTypeImageConfig); ok {
+		t.Error("Expected a config media type not to be a layer media type")
+	}
+}
+
+func TestDownloadLayerTar(t *testing.T) {
+	fake, r := newFakeRegistry(t)
+	content := []byte("not really a tar archive")
+
+	var gzipped bytes.Buffer
+	gzipWriter := gzip.NewWriter(&gzipped)
+	_, _ = gzipWriter.Write(content)
+	_ = gzipWriter.Close()
+
+	zstdWriter, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zstded := zstdWriter.EncodeAll(content, nil)
+
+	for _, test := range []struct {
+		mediaType   string
+		blob        []byte
+		compression Compression
+	}{
+		{MediaTypeImageLayer, content, CompressionNone},
+		{MediaTypeImageLayerGzip, gzipped.Bytes(), CompressionGzip},
+		{MediaTypeImageLayerZstd, zstded, CompressionZstd},
+		// Uncompressed content pushed with a compressed media type.
+		{schema2.MediaTypeLayer, content, CompressionNone},
+	} {
+		d := digest.FromBytes(test.blob)
+		fake.blobs[d] = test.blob
+		descriptor := distribution.Descriptor{MediaType: test.mediaType, Size: int64(len(test.blob)), Digest: d}
+
+		tar, compression, err := r.DownloadLayerTar("test/image", descriptor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := io.ReadAll(tar)
+		tar.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if compression != test.compression || !bytes.Equal(actual, content) {
+			t.Errorf("Unexpected %s layer of media type %s: %q", compression, test.mediaType, actual)
+		}
+	}
+
+	d := digest.FromBytes(zstded)
+	if _, _, err := r.DownloadLayerTar("test/image", distribution.Descriptor{MediaType: MediaTypeImageLayerGzip, Digest: d}); err == nil {
+		t.Error("Expected an error for a zstd layer with a gzip media type")
+	}
+}
+
+func TestLayerEStargz(t *testing.T) {
+	tocDigest := digest.FromString("toc")
+	estargz, ok := LayerEStargz(distribution.Descriptor{
+		MediaType: MediaTypeImageLayerGzip,
+		Annotations: map[string]string{
+			AnnotationEStargzTOCDigest:        tocDigest.String(),
+			AnnotationEStargzUncompressedSize: "1024",
+		},
+	})
+	if !ok || estargz.TOCDigest != tocDigest || estargz.UncompressedSize != 1024 {
+		t.Errorf("Unexpected eStargz annotations: %+v, %v", estargz, ok)
+	}
+
+	if _, ok := LayerEStargz(distribution.Descriptor{MediaType: MediaTypeImageLayerGzip}); ok {
+		t.Error("Expected a plain gzip layer not to be eStargz")
+	}
+}
@@ -0,0 +1,320 @@
+package registry
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/manifest/ocischema"
+	"github.com/docker/distribution/manifest/schema1"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/opencontainers/go-digest"
+)
+
+// Media type equivalences between Docker schema2 and OCI.
+var (
+	dockerToOCIMediaTypes = map[string]string{
+		schema2.MediaTypeManifest:          MediaTypeImageManifest,
+		manifestlist.MediaTypeManifestList: MediaTypeImageIndex,
+		schema2.MediaTypeImageConfig:       MediaTypeImageConfig,
+		schema2.MediaTypeLayer:             MediaTypeImageLayerGzip,
+		schema2.MediaTypeUncompressedLayer: MediaTypeImageLayer,
+		schema2.MediaTypeForeignLayer:      MediaTypeImageLayerNonDistributableGzip,
+	}
+	ociToDockerMediaTypes = invertMediaTypes(dockerToOCIMediaTypes)
+)
+
+func invertMediaTypes(mediaTypes map[string]string) map[string]string {
+	inverted := make(map[string]string, len(mediaTypes))
+	for k, v := range mediaTypes {
+		inverted[v] = k
+	}
+	return inverted
+}
+
+func convertDescriptor(descriptor distribution.Descriptor, mediaTypes map[string]string) (distribution.Descriptor, error) {
+	mediaType, ok := mediaTypes[descriptor.MediaType]
+	if !ok {
+		return distribution.Descriptor{}, fmt.Errorf("no equivalent for media type %q", descriptor.MediaType)
+	}
+	descriptor.MediaType = mediaType
+	return descriptor, nil
+}
+
+// DescribeManifest returns the descriptor of the given manifest's payload.
+func DescribeManifest(m distribution.Manifest) (distribution.Descriptor, error) {
+	mediaType, payload, err := m.Payload()
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	return distribution.Descriptor{
+		MediaType: mediaType,
+		Size:      int64(len(payload)),
+		Digest:    digest.FromBytes(payload),
+	}, nil
+}
+
+// Schema2ToOCI converts a Docker schema2 manifest to an OCI image manifest,
+// mapping the media types of the config and layers.
+func Schema2ToOCI(m *schema2.DeserializedManifest) (*ocischema.DeserializedManifest, error) {
+	config, err := convertDescriptor(m.Config, dockerToOCIMediaTypes)
+	if err != nil {
+		return nil, err
+	}
+	layers := make([]distribution.Descriptor, len(m.Layers))
+	for i, layer := range m.Layers {
+		if layers[i], err = convertDescriptor(layer, dockerToOCIMediaTypes); err != nil {
+			return nil, err
+		}
+	}
+
+	return ocischema.FromStruct(ocischema.Manifest{
+		Versioned: ocischema.SchemaVersion,
+		Config:    config,
+		Layers:    layers,
+	})
+}
+
+// OCIToSchema2 converts an OCI image manifest to a Docker schema2 manifest,
+// mapping the media types of the config and layers. Manifest annotations
+// cannot be represented in schema2 and are dropped. Layers without a Docker
+// equivalent, such as zstd compressed layers, result in an error.
+func OCIToSchema2(m *ocischema.DeserializedManifest) (*schema2.DeserializedManifest, error) {
+	config, err := convertDescriptor(m.Config, ociToDockerMediaTypes)
+	if err != nil {
+		return nil, err
+	}
+	layers := make([]distribution.Descriptor, len(m.Layers))
+	for i, layer := range m.Layers {
+		if layers[i], err = convertDescriptor(layer, ociToDockerMediaTypes); err != nil {
+			return nil, err
+		}
+		layers[i].Annotations = nil
+	}
+
+	return schema2.FromStruct(schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		Config:    config,
+		Layers:    layers,
+	})
+}
+
+// ConvertIndex converts an index to the given media type, either
+// MediaTypeImageIndex or manifestlist.MediaTypeManifestList.
+//
+// Converting an index also requires converting the manifests it references,
+// which changes their digests. converted maps the digest of each original
+// manifest to the descriptor of its converted counterpart (see
+// DescribeManifest); entries found there are replaced while keeping their
+// platform and annotations. Entries not found there must already be of the
+// target format. Converting to a manifest list drops all annotations.
+//
+// An index fetched with ManifestList or ImageIndex can be passed as
+// Index{Versioned: list.Versioned, Manifests: list.Manifests}.
+func ConvertIndex(index Index, mediaType string, converted map[digest.Digest]distribution.Descriptor) (*DeserializedIndex, error) {
+	var manifestMediaType string
+	switch mediaType {
+	case MediaTypeImageIndex:
+		manifestMediaType = MediaTypeImageManifest
+	case manifestlist.MediaTypeManifestList:
+		manifestMediaType = schema2.MediaTypeManifest
+	default:
+		return nil, fmt.Errorf("unsupported index media type %q", mediaType)
+	}
+
+	manifests := make([]manifestlist.ManifestDescriptor, len(index.Manifests))
+	for i, entry := range index.Manifests {
+		if replacement, ok := converted[entry.Digest]; ok {
+			entry.MediaType = replacement.MediaType
+			entry.Size = replacement.Size
+			entry.Digest = replacement.Digest
+		}
+		if entry.MediaType != manifestMediaType {
+			return nil, fmt.Errorf("manifest %s of type %q has not been converted to %q", entry.Digest, entry.MediaType, manifestMediaType)
+		}
+		if mediaType == manifestlist.MediaTypeManifestList {
+			entry.Annotations = nil
+		}
+		manifests[i] = entry
+	}
+
+	result := Index{
+		Versioned: manifest.Versioned{
+			SchemaVersion: 2,
+			MediaType:     mediaType,
+		},
+		Manifests: manifests,
+	}
+	if mediaType == MediaTypeImageIndex {
+		result.Annotations = index.Annotations
+	}
+	return IndexFromStruct(result)
+}
+
+// v1Compatibility holds the fields of a schema1 history entry which are
+// needed to reconstruct an image config.
+type v1Compatibility struct {
+	Created         time.Time `json:"created"`
+	Author          string    `json:"author,omitempty"`
+	Comment         string    `json:"comment,omitempty"`
+	ThrowAway       bool      `json:"throwaway,omitempty"`
+	ContainerConfig struct {
+		Cmd []string
+	} `json:"container_config,omitempty"`
+}
+
+type configRootFS struct {
+	Type    string          `json:"type"`
+	DiffIDs []digest.Digest `json:"diff_ids"`
+}
+
+type configHistory struct {
+	Created    time.Time `json:"created"`
+	Author     string    `json:"author,omitempty"`
+	CreatedBy  string    `json:"created_by,omitempty"`
+	Comment    string    `json:"comment,omitempty"`
+	EmptyLayer bool      `json:"empty_layer,omitempty"`
+}
+
+// UpgradeSchema1 converts a schema1 manifest into a Docker schema2 manifest.
+// The image config is synthesized from the v1Compatibility history, which
+// requires downloading every non-empty layer to compute its uncompressed
+// digest. The returned config blob must be uploaded to the target repository
+// (for example with UploadLayer) before the manifest is pushed.
+func (registry *Registry) UpgradeSchema1(repository string, m *schema1.SignedManifest) (*schema2.DeserializedManifest, []byte, error) {
+	if len(m.History) == 0 || len(m.History) != len(m.FSLayers) {
+		return nil, nil, fmt.Errorf("schema1 manifest has %d history entries and %d layers", len(m.History), len(m.FSLayers))
+	}
+
+	var (
+		layers  []distribution.Descriptor
+		diffIDs []digest.Digest
+		history []configHistory
+	)
+	// Schema1 lists the topmost layer first.
+	for i := len(m.History) - 1; i >= 0; i-- {
+		var compat v1Compatibility
+		if err := json.Unmarshal([]byte(m.History[i].V1Compatibility), &compat); err != nil {
+			return nil, nil, fmt.Errorf("invalid v1Compatibility entry %d: %w", i, err)
+		}
+		history = append(history, configHistory{
+			Created:    compat.Created,
+			Author:     compat.Author,
+			CreatedBy:  strings.Join(compat.ContainerConfig.Cmd, " "),
+			Comment:    compat.Comment,
+			EmptyLayer: compat.ThrowAway,
+		})
+		if compat.ThrowAway {
+			continue
+		}
+
+		blobSum := m.FSLayers[i].BlobSum
+		size, diffID, err := registry.diffID(repository, blobSum)
+		if err != nil {
+			return nil, nil, err
+		}
+		layers = append(layers, distribution.Descriptor{
+			MediaType: schema2.MediaTypeLayer,
+			Size:      size,
+			Digest:    blobSum,
+		})
+		diffIDs = append(diffIDs, diffID)
+	}
+
+	config, err := configFromV1Compatibility([]byte(m.History[0].V1Compatibility), diffIDs, history)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	deserialized, err := schema2.FromStruct(schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		Config: distribution.Descriptor{
+			MediaType: schema2.MediaTypeImageConfig,
+			Size:      int64(len(config)),
+			Digest:    digest.FromBytes(config),
+		},
+		Layers: layers,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return deserialized, config, nil
+}
+
+// configFromV1Compatibility turns the topmost v1Compatibility entry into an
+// image config by removing the v1 specific fields and adding the rootfs and
+// history, the same way the Docker daemon does when pulling schema1 images.
+func configFromV1Compatibility(v1Config []byte, diffIDs []digest.Digest, history []configHistory) ([]byte, error) {
+	var config map[string]json.RawMessage
+	if err := json.Unmarshal(v1Config, &config); err != nil {
+		return nil, fmt.Errorf("invalid v1Compatibility entry: %w", err)
+	}
+	for _, key := range []string{"id", "parent", "Size", "parent_id", "layer_id", "throwaway"} {
+		delete(config, key)
+	}
+
+	if diffIDs == nil {
+		diffIDs = []digest.Digest{}
+	}
+	rootFS, err := json.Marshal(configRootFS{Type: "layers", DiffIDs: diffIDs})
+	if err != nil {
+		return nil, err
+	}
+	config["rootfs"] = rootFS
+
+	historyJSON, err := json.Marshal(history)
+	if err != nil {
+		return nil, err
+	}
+	config["history"] = historyJSON
+
+	return json.Marshal(config)
+}
+
+// diffID downloads a gzip compressed layer, verifying it against its digest,
+// and returns its compressed size and the digest of its uncompressed content.
+func (registry *Registry) diffID(repository string, blobSum digest.Digest) (int64, digest.Digest, error) {
+	blob, err := registry.DownloadLayer(repository, blobSum)
+	if err != nil {
+		return 0, "", err
+	}
+	defer blob.Close()
+
+	verifier := blobSum.Verifier()
+	counter := &countingWriter{}
+	compressed := io.TeeReader(blob, io.MultiWriter(verifier, counter))
+
+	gz, err := gzip.NewReader(compressed)
+	if err != nil {
+		return 0, "", fmt.Errorf("layer %s: %w", blobSum, err)
+	}
+	defer gz.Close()
+
+	digester := digest.Canonical.Digester()
+	if _, err := io.Copy(digester.Hash(), gz); err != nil {
+		return 0, "", fmt.Errorf("layer %s: %w", blobSum, err)
+	}
+	// Drain anything after the end of the gzip stream so the digest covers the whole blob.
+	if _, err := io.Copy(io.Discard, compressed); err != nil {
+		return 0, "", err
+	}
+	if !verifier.Verified() {
+		return 0, "", fmt.Errorf("layer %s failed digest verification", blobSum)
+	}
+	return counter.n, digester.Digest(), nil
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
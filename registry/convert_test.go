@@ -0,0 +1,105 @@
+package registry
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/google/go-cmp/cmp"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestSchema2OCIRoundTrip(t *testing.T) {
+	original, err := schema2.FromStruct(schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		Config:    distribution.Descriptor{MediaType: schema2.MediaTypeImageConfig, Size: 2, Digest: digest.FromString("{}")},
+		Layers: []distribution.Descriptor{
+			{MediaType: schema2.MediaTypeLayer, Size: 1, Digest: digest.FromString("a")},
+			{MediaType: schema2.MediaTypeForeignLayer, Size: 1, Digest: digest.FromString("b"), URLs: []string{"https://example.com/b"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oci, err := Schema2ToOCI(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oci.Config.MediaType != MediaTypeImageConfig {
+		t.Errorf("Expected config media type %q but got: %q", MediaTypeImageConfig, oci.Config.MediaType)
+	}
+	if oci.Layers[1].MediaType != MediaTypeImageLayerNonDistributableGzip {
+		t.Errorf("Expected layer media type %q but got: %q", MediaTypeImageLayerNonDistributableGzip, oci.Layers[1].MediaType)
+	}
+
+	back, err := OCIToSchema2(oci)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(original.Manifest, back.Manifest); diff != "" {
+		t.Errorf("Round trip mismatch (-want +got):\n%s", diff)
+	}
+
+	oci.Layers[0].MediaType = "application/vnd.oci.image.layer.v1.tar+zstd"
+	if _, err := OCIToSchema2(oci); err == nil {
+		t.Error("Expected zstd layers to be rejected")
+	}
+}
+
+func TestConvertIndex(t *testing.T) {
+	original := digest.FromString("original")
+	converted := distribution.Descriptor{MediaType: MediaTypeImageManifest, Size: 10, Digest: digest.FromString("converted")}
+	index := Index{
+		Manifests: []manifestlist.ManifestDescriptor{{
+			Descriptor: distribution.Descriptor{MediaType: schema2.MediaTypeManifest, Size: 5, Digest: original},
+			Platform:   manifestlist.PlatformSpec{Architecture: "amd64", OS: "linux"},
+		}},
+	}
+
+	if _, err := ConvertIndex(index, MediaTypeImageIndex, nil); err == nil {
+		t.Error("Expected unconverted manifests to be rejected")
+	}
+
+	result, err := ConvertIndex(index, MediaTypeImageIndex, map[digest.Digest]distribution.Descriptor{original: converted})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.MediaType != MediaTypeImageIndex || result.Manifests[0].Digest != converted.Digest {
+		t.Errorf("Unexpected converted index: %+v", result.Index)
+	}
+	if result.Manifests[0].Platform.Architecture != "amd64" {
+		t.Errorf("Expected platform to be kept, got: %+v", result.Manifests[0].Platform)
+	}
+}
+
+func TestConfigFromV1Compatibility(t *testing.T) {
+	v1Config := `{"id":"abc","parent":"def","architecture":"amd64","os":"linux","config":{"Cmd":["sh"]}}`
+	diffIDs := []digest.Digest{digest.FromString("layer")}
+	history := []configHistory{{CreatedBy: "/bin/sh -c #(nop) ADD file"}}
+
+	config, err := configFromV1Compatibility([]byte(v1Config), diffIDs, history)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(config, &got); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got["id"]; ok {
+		t.Error("Expected id to be removed from the config")
+	}
+	if _, ok := got["parent"]; ok {
+		t.Error("Expected parent to be removed from the config")
+	}
+	if got["architecture"] != "amd64" {
+		t.Errorf("Expected architecture to be kept, got: %v", got["architecture"])
+	}
+	rootFS := got["rootfs"].(map[string]interface{})
+	if ids := rootFS["diff_ids"].([]interface{}); len(ids) != 1 || ids[0] != diffIDs[0].String() {
+		t.Errorf("Unexpected diff_ids: %v", ids)
+	}
+}
@@ -0,0 +1,259 @@
+package registry
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512" // registers SHA-384 and SHA-512 for ecdsaHashes
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// MediaTypeCosignSimpleSigning specifies the media type of cosign signature payloads.
+	MediaTypeCosignSimpleSigning = "application/vnd.dev.cosign.simplesigning.v1+json"
+	// ArtifactTypeCosignSignature specifies the artifact type of cosign signatures stored as referrers.
+	ArtifactTypeCosignSignature = "application/vnd.dev.cosign.artifact.sig.v1+json"
+
+	// AnnotationCosignSignature holds the base64 encoded signature of a payload layer.
+	AnnotationCosignSignature = "dev.cosignproject.cosign/signature"
+	// AnnotationCosignCertificate holds the PEM encoded signing certificate, if any.
+	AnnotationCosignCertificate = "dev.sigstore.cosign/certificate"
+	// AnnotationCosignChain holds the PEM encoded certificate chain, if any.
+	AnnotationCosignChain = "dev.sigstore.cosign/chain"
+	// AnnotationCosignBundle holds the transparency log bundle, if any.
+	AnnotationCosignBundle = "dev.sigstore.cosign/bundle"
+
+	// cosignSignatureType is the critical.type of cosign simple signing payloads.
+	cosignSignatureType = "cosign container image signature"
+)
+
+var (
+	// ErrSignatureInvalid is returned when a signature cannot be verified with any of the given keys.
+	ErrSignatureInvalid = errors.New("signature verification failed")
+)
+
+// SimpleSigningPayload is the signed payload of a cosign signature.
+type SimpleSigningPayload struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest digest.Digest `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]interface{} `json:"optional,omitempty"`
+}
+
+// Signature is a cosign signature found for an image.
+type Signature struct {
+	// Manifest is the digest of the signature manifest the signature was found in.
+	Manifest digest.Digest
+	// Layer describes the payload blob.
+	Layer distribution.Descriptor
+	// Payload is the raw signed payload.
+	Payload []byte
+	// SimpleSigning is the decoded payload.
+	SimpleSigning SimpleSigningPayload
+	// Signature is the decoded signature over Payload.
+	Signature []byte
+	// Certificate, Chain and Bundle hold the corresponding annotations, if present.
+	Certificate string
+	Chain       string
+	Bundle      string
+}
+
+// CosignSignatureTag returns the tag cosign stores the signatures of the given digest under.
+func CosignSignatureTag(d digest.Digest) string {
+	return fmt.Sprintf("%s-%s.sig", d.Algorithm(), d.Encoded())
+}
+
+// Signatures returns the cosign signatures of the given image digest, found
+// either under the cosign signature tag or as referrers of the image.
+// The signatures are not verified; use Verify to check them.
+// If either source fails, the signatures found elsewhere are returned
+// together with the first error.
+func (registry *Registry) Signatures(repository string, d digest.Digest) ([]Signature, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid digest %v: %w", d, err)
+	}
+
+	var signatures []Signature
+	var firstErr error
+	tagged, err := registry.signaturesFromManifest(repository, CosignSignatureTag(d))
+	if err != nil && !isNotFound(err) {
+		firstErr = err
+	}
+	signatures = append(signatures, tagged...)
+
+	referrers, err := registry.Referrers(repository, d, ArtifactTypeCosignSignature)
+	if err != nil && firstErr == nil {
+		firstErr = err
+	}
+	for _, referrer := range referrers {
+		found, err := registry.signaturesFromManifest(repository, referrer.Digest.String())
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		signatures = append(signatures, found...)
+	}
+	return uniqueSignatures(signatures), firstErr
+}
+
+// uniqueSignatures removes signatures found both under the signature tag and
+// as a referrer.
+func uniqueSignatures(signatures []Signature) []Signature {
+	var unique []Signature
+	seen := make(map[string]bool)
+	for _, signature := range signatures {
+		key := signature.Layer.Digest.String() + string(signature.Signature)
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, signature)
+		}
+	}
+	return unique
+}
+
+func (registry *Registry) signaturesFromManifest(repository, reference string) ([]Signature, error) {
+	payload, _, err := registry.fetchManifest(repository, reference, MediaTypeImageManifest, schema2.MediaTypeManifest)
+	if err != nil {
+		return nil, err
+	}
+	var document manifestDocument
+	if err := json.Unmarshal(payload, &document); err != nil {
+		return nil, err
+	}
+
+	manifestDigest := digest.FromBytes(payload)
+	var signatures []Signature
+	for _, layer := range document.Layers {
+		encoded, ok := layer.Annotations[AnnotationCosignSignature]
+		if !ok || layer.MediaType != MediaTypeCosignSimpleSigning {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signature annotation on layer %s: %w", layer.Digest, err)
+		}
+
+		content, err := registry.fetchBlob(repository, layer)
+		if err != nil {
+			return nil, err
+		}
+		signature := Signature{
+			Manifest:    manifestDigest,
+			Layer:       layer,
+			Payload:     content,
+			Signature:   decoded,
+			Certificate: layer.Annotations[AnnotationCosignCertificate],
+			Chain:       layer.Annotations[AnnotationCosignChain],
+			Bundle:      layer.Annotations[AnnotationCosignBundle],
+		}
+		if err := json.Unmarshal(content, &signature.SimpleSigning); err != nil {
+			return nil, fmt.Errorf("invalid simple signing payload %s: %w", layer.Digest, err)
+		}
+		signatures = append(signatures, signature)
+	}
+	return signatures, nil
+}
+
+// Verify checks that the signature was made over a payload for the given
+// image digest by one of the given public keys. It does not contact any
+// server. ECDSA, RSA and ed25519 keys are supported.
+func (s Signature) Verify(d digest.Digest, keys ...crypto.PublicKey) error {
+	if s.SimpleSigning.Critical.Type != cosignSignatureType {
+		return fmt.Errorf("unexpected signature payload type %q", s.SimpleSigning.Critical.Type)
+	}
+	if signed := s.SimpleSigning.Critical.Image.DockerManifestDigest; signed != d {
+		return fmt.Errorf("signature is for %s, not %s", signed, d)
+	}
+	return verifySignature(s.Payload, s.Signature, keys)
+}
+
+// VerifiedSignatures returns those signatures which Verify accepts for the
+// given digest and keys.
+func VerifiedSignatures(signatures []Signature, d digest.Digest, keys ...crypto.PublicKey) []Signature {
+	var verified []Signature
+	for _, signature := range signatures {
+		if signature.Verify(d, keys...) == nil {
+			verified = append(verified, signature)
+		}
+	}
+	return verified
+}
+
+// ParsePublicKey parses a PEM encoded PKIX public key, such as cosign.pub.
+func ParsePublicKey(pemBytes []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("no PEM block found in public key")
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// verifySignature checks a signature over payload against each of the keys
+// in turn, returning nil as soon as one of them verifies it.
+func verifySignature(payload, signature []byte, keys []crypto.PublicKey) error {
+	if len(keys) == 0 {
+		return errors.New("no public keys given")
+	}
+	for _, key := range keys {
+		if verifyWithKey(key, payload, signature) {
+			return nil
+		}
+	}
+	return ErrSignatureInvalid
+}
+
+func verifyWithKey(key crypto.PublicKey, payload, signature []byte) bool {
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		for _, hash := range ecdsaHashes(key.Curve) {
+			h := hash.New()
+			h.Write(payload)
+			if ecdsa.VerifyASN1(key, h.Sum(nil), signature) {
+				return true
+			}
+		}
+	case *rsa.PublicKey:
+		hashed := sha256.Sum256(payload)
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], signature) == nil {
+			return true
+		}
+		return rsa.VerifyPSS(key, crypto.SHA256, hashed[:], signature, nil) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, payload, signature)
+	case *ed25519.PublicKey:
+		return ed25519.Verify(*key, payload, signature)
+	}
+	return false
+}
+
+// ecdsaHashes returns the digests ECDSA signatures are commonly made with for
+// the given curve. Cosign uses SHA-256 regardless of the curve by default.
+func ecdsaHashes(curve elliptic.Curve) []crypto.Hash {
+	switch curve {
+	case elliptic.P384():
+		return []crypto.Hash{crypto.SHA256, crypto.SHA384}
+	case elliptic.P521():
+		return []crypto.Hash{crypto.SHA256, crypto.SHA512}
+	}
+	return []crypto.Hash{crypto.SHA256}
+}
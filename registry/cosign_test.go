@@ -0,0 +1,88 @@
+package registry
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestSignatures(t *testing.T) {
+	fake, r := newFakeRegistry(t)
+	image := pushTestImage(t, r, "test/image", `{"architecture":"amd64","os":"linux"}`)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":"example.com/test/image"},"image":{"docker-manifest-digest":%q},"type":"cosign container image signature"},"optional":null}`, image))
+	hashed := sha256.Sum256(payload)
+	signature, err := ecdsa.SignASN1(rand.Reader, key, hashed[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = r.PushArtifact("test/image", CosignSignatureTag(image), Artifact{
+		ConfigMediaType: MediaTypeImageConfig,
+		Config:          []byte(`{}`),
+		Blobs: []ArtifactBlob{{
+			MediaType:   MediaTypeCosignSimpleSigning,
+			Annotations: map[string]string{AnnotationCosignSignature: base64.StdEncoding.EncodeToString(signature)},
+			Content:     payload,
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signatures, err := r.Signatures("test/image", image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signatures) != 1 {
+		t.Fatalf("Expected 1 signature but got: %d", len(signatures))
+	}
+	if got := signatures[0].SimpleSigning.Critical.Identity.DockerReference; got != "example.com/test/image" {
+		t.Errorf("Unexpected docker-reference: %q", got)
+	}
+
+	fake.referrersStatus = http.StatusInternalServerError
+	tagged, err := r.Signatures("test/image", image)
+	if err == nil {
+		t.Error("Expected the referrers error to be returned")
+	}
+	if len(tagged) != 1 {
+		t.Errorf("Expected the tagged signature despite the referrers error but got: %d", len(tagged))
+	}
+	fake.referrersStatus = 0
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := signatures[0].Verify(image, publicKey); err != nil {
+		t.Errorf("Expected signature to verify but got: %v", err)
+	}
+
+	otherKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := signatures[0].Verify(image, otherKey); err == nil {
+		t.Error("Expected verification with the wrong key to fail")
+	}
+	if err := signatures[0].Verify(fakeDigest, publicKey); err == nil {
+		t.Error("Expected verification for another image digest to fail")
+	}
+}
@@ -0,0 +1,156 @@
+package registry
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// DeleteDisabledError is returned when the registry refuses a deletion because
+// it does not support it or has it disabled, which the reference registry
+// reports with 405 Method Not Allowed or an UNSUPPORTED error code.
+type DeleteDisabledError struct {
+	Reference string
+	Err       error
+}
+
+func (err *DeleteDisabledError) Error() string {
+	return fmt.Sprintf("registry does not allow deleting %s: %v", err.Reference, err.Err)
+}
+
+func (err *DeleteDisabledError) Unwrap() error {
+	return err.Err
+}
+
+// ManifestInUseError is returned when deleting a manifest is refused because
+// other tags still point to it.
+type ManifestInUseError struct {
+	Digest digest.Digest
+	Tags   []string
+}
+
+func (err *ManifestInUseError) Error() string {
+	return fmt.Sprintf("manifest %s is still referenced by tags: %s", err.Digest, strings.Join(err.Tags, ", "))
+}
+
+// DeleteOptions controls how DeleteTag and DeleteByReference remove a tag.
+type DeleteOptions struct {
+	// UseTagEndpoint removes only the tag by sending DELETE for the tag itself,
+	// as allowed by the OCI distribution spec, instead of deleting the manifest
+	// it points to. Registries which do not support this answer with a
+	// *DeleteDisabledError.
+	UseTagEndpoint bool
+	// RefuseIfReferenced refuses to delete a manifest which tags other than
+	// the one being deleted still point to, returning a *ManifestInUseError.
+	RefuseIfReferenced bool
+}
+
+// DeleteTag resolves the tag to a digest with a HEAD request and deletes the
+// manifest it points to, which also removes every other tag of that manifest
+// unless options.UseTagEndpoint is set. It returns the digest the tag pointed to.
+func (registry *Registry) DeleteTag(repository, tag string, options DeleteOptions) (digest.Digest, error) {
+	d, _, err := registry.ManifestDigest(repository, tag)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve tag %s: %w", tag, err)
+	}
+
+	if options.UseTagEndpoint {
+		return d, registry.deleteManifest(repository, tag)
+	}
+
+	if options.RefuseIfReferenced {
+		if err := registry.checkUnreferenced(repository, d, tag); err != nil {
+			return d, err
+		}
+	}
+	return d, registry.deleteManifest(repository, d.String())
+}
+
+// DeleteByReference deletes the manifest identified by either a tag or a
+// digest, applying the given options, and returns the deleted digest.
+func (registry *Registry) DeleteByReference(repository, reference string, options DeleteOptions) (digest.Digest, error) {
+	d, err := digest.Parse(reference)
+	if err != nil {
+		return registry.DeleteTag(repository, reference, options)
+	}
+
+	if options.RefuseIfReferenced {
+		if err := registry.checkUnreferenced(repository, d, ""); err != nil {
+			return d, err
+		}
+	}
+	return d, registry.deleteManifest(repository, d.String())
+}
+
+// checkUnreferenced returns a *ManifestInUseError if any tag other than
+// ignoredTag points to the given digest.
+func (registry *Registry) checkUnreferenced(repository string, d digest.Digest, ignoredTag string) error {
+	tags, err := registry.Tags(repository)
+	if err != nil {
+		return err
+	}
+
+	var referencing []string
+	for _, tag := range tags {
+		if tag == ignoredTag {
+			continue
+		}
+		tagged, _, err := registry.ManifestDigest(repository, tag)
+		if err != nil {
+			if isNotFound(err) {
+				continue
+			}
+			return err
+		}
+		if tagged == d {
+			referencing = append(referencing, tag)
+		}
+	}
+
+	if len(referencing) > 0 {
+		return &ManifestInUseError{Digest: d, Tags: referencing}
+	}
+	return nil
+}
+
+func (registry *Registry) deleteManifest(repository, reference string) error {
+	url := registry.url("/v2/%s/manifests/%s", repository, reference)
+	registry.Logf("registry.manifest.delete url=%s repository=%s reference=%s", url, repository, reference)
+
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := registry.Client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		if isDeleteDisabled(err) {
+			return &DeleteDisabledError{Reference: reference, Err: err}
+		}
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return NewClientError(resp.StatusCode, fmt.Errorf("unexpected status deleting %s: %s", reference, resp.Status))
+	}
+	return nil
+}
+
+func isDeleteDisabled(err error) bool {
+	httpErr, ok := httpStatusError(err)
+	if !ok {
+		return false
+	}
+	switch httpErr.Response.StatusCode {
+	case http.StatusMethodNotAllowed:
+		return true
+	case http.StatusBadRequest:
+		return bytes.Contains(httpErr.Body, []byte(`"UNSUPPORTED"`))
+	}
+	return false
+}
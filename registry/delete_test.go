@@ -0,0 +1,69 @@
+package registry
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDeleteTag(t *testing.T) {
+	fake, r := newFakeRegistry(t)
+
+	d := pushTestImage(t, r, "test/image", `{"architecture":"amd64","os":"linux"}`)
+	m, err := r.ManifestOCI("test/image", d.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tag := range []string{"v1", "latest"} {
+		if err := r.PutManifest("test/image", tag, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = r.DeleteTag("test/image", "v1", DeleteOptions{RefuseIfReferenced: true})
+	var inUseErr *ManifestInUseError
+	if !errors.As(err, &inUseErr) {
+		t.Fatalf("Expected *ManifestInUseError but got: %v", err)
+	}
+
+	deleted, err := r.DeleteTag("test/image", "v1", DeleteOptions{UseTagEndpoint: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != d {
+		t.Errorf("Expected deleted digest %s but got: %s", d, deleted)
+	}
+	if _, ok := fake.tags["v1"]; ok {
+		t.Error("Expected tag v1 to be removed")
+	}
+	if _, ok := fake.manifests[d]; !ok {
+		t.Error("Expected manifest to be kept when only the tag is deleted")
+	}
+
+	if _, err := r.DeleteByReference("test/image", d.String(), DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.manifests[d]; ok {
+		t.Error("Expected manifest to be deleted")
+	}
+}
+
+func TestDeleteDisabled(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}))
+	t.Cleanup(s.Close)
+
+	r, err := New(s.URL, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Logf = Quiet
+
+	err = r.DeleteManifest("test/image", fakeDigest)
+	var disabledErr *DeleteDisabledError
+	if !errors.As(err, &disabledErr) {
+		t.Errorf("Expected *DeleteDisabledError but got: %v", err)
+	}
+}
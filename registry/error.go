@@ -1,6 +1,19 @@
 package registry
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+var (
+	// ErrDigestMismatch is returned when content does not match the digest it was expected to have.
+	ErrDigestMismatch = errors.New("digest mismatch")
+	// ErrSizeMismatch is returned when content does not have the size it was expected to have.
+	// It wraps ErrDigestMismatch.
+	ErrSizeMismatch = fmt.Errorf("size mismatch: %w", ErrDigestMismatch)
+)
 
 type ClientError struct {
 	code int
@@ -25,3 +38,20 @@ func (c *ClientError) OrigErr() error {
 func (c *ClientError) Error() string {
 	return fmt.Sprintf("%d: %v\n", c.Code(), c.OrigErr())
 }
+
+// httpStatusError extracts the *HttpStatusError produced by ErrorTransport
+// from an error returned by the http.Client, if there is one.
+func httpStatusError(err error) (*HttpStatusError, bool) {
+	urlErr, ok := err.(*url.Error)
+	if !ok {
+		return nil, false
+	}
+	httpErr, ok := urlErr.Err.(*HttpStatusError)
+	return httpErr, ok
+}
+
+// isNotFound reports whether err is a 404 response from the registry.
+func isNotFound(err error) bool {
+	httpErr, ok := httpStatusError(err)
+	return ok && httpErr.Response.StatusCode == http.StatusNotFound
+}
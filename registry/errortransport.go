@@ -19,6 +19,12 @@ var _ error = &HttpStatusError{}
 
 type ErrorTransport struct {
 	Transport
+	// MaxBodySize bounds the part of an error response body kept in
+	// HttpStatusError.Body; the rest is discarded. Defaults to the
+	// MaxErrorBodySize of the registry's Limits.
+	MaxBodySize int64
+
+	limits *Limits
 }
 
 func (t *ErrorTransport) RoundTrip(request *http.Request) (*http.Response, error) {
@@ -29,7 +35,7 @@ func (t *ErrorTransport) RoundTrip(request *http.Request) (*http.Response, error
 
 	if resp.StatusCode >= 400 {
 		defer resp.Body.Close()
-		body, err := io.ReadAll(resp.Body)
+		body, err := io.ReadAll(io.LimitReader(resp.Body, limitOrDefault(t.MaxBodySize, t.limits.maxErrorBodySize())))
 		if err != nil {
 			return nil, fmt.Errorf("http: failed to read response body (status=%v, err=%q)", resp.StatusCode, err)
 		}
@@ -0,0 +1,112 @@
+package registry
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// maxConcurrentManifestChecks limits the number of requests HasManifests has
+// in flight at once.
+const maxConcurrentManifestChecks = 8
+
+// ManifestStatus describes whether a manifest exists in a repository.
+type ManifestStatus struct {
+	Reference string
+	Exists    bool
+	// Digest and MediaType are only set if the manifest exists. Digest is
+	// empty if the registry did not report it and it could not be computed.
+	Digest    digest.Digest
+	MediaType string
+}
+
+// HasManifest checks whether the manifest with the given tag or digest exists.
+// Unlike ManifestDigest, a missing manifest is not an error but is reported
+// with Exists set to false. Registries which do not allow HEAD requests for
+// manifests are asked with a GET request instead.
+func (registry *Registry) HasManifest(repository, reference string) (ManifestStatus, error) {
+	status := ManifestStatus{Reference: reference}
+
+	method := http.MethodHead
+	resp, err := registry.manifestRequest(method, repository, reference)
+	if httpErr, ok := httpStatusError(err); ok && httpErr.Response.StatusCode == http.StatusMethodNotAllowed {
+		method = http.MethodGet
+		resp, err = registry.manifestRequest(method, repository, reference)
+	}
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		if isNotFound(err) {
+			return status, nil
+		}
+		return status, err
+	}
+
+	status.Exists = true
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+		status.MediaType = mediaType
+	}
+	if d, err := digest.Parse(resp.Header.Get("Docker-Content-Digest")); err == nil {
+		status.Digest = d
+	} else if d, err := digest.Parse(reference); err == nil {
+		status.Digest = d
+	} else if method == http.MethodGet {
+		payload, err := registry.readManifest(resp.Body)
+		if err != nil {
+			return status, err
+		}
+		status.Digest = digest.FromBytes(payload)
+	}
+	return status, nil
+}
+
+// HasManifests checks the given references concurrently with HasManifest and
+// returns their statuses in the same order. If any check fails, the first
+// error is returned alongside the statuses of the checks which succeeded.
+func (registry *Registry) HasManifests(repository string, references []string) ([]ManifestStatus, error) {
+	statuses := make([]ManifestStatus, len(references))
+	errs := make([]error, len(references))
+
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, maxConcurrentManifestChecks)
+	for i, reference := range references {
+		wg.Add(1)
+		go func(i int, reference string) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			statuses[i], errs[i] = registry.HasManifest(repository, reference)
+		}(i, reference)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return statuses, fmt.Errorf("checking manifest %s: %w", references[i], err)
+		}
+	}
+	return statuses, nil
+}
+
+func (registry *Registry) manifestRequest(method, repository, reference string) (*http.Response, error) {
+	url := registry.url("/v2/%s/manifests/%s", repository, reference)
+	if method == http.MethodHead {
+		registry.Logf("registry.manifest.head url=%s repository=%s reference=%s", url, repository, reference)
+	} else {
+		registry.Logf("registry.manifest.get url=%s repository=%s reference=%s", url, repository, reference)
+	}
+
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, mediaType := range manifestMediaTypes {
+		req.Header.Add("Accept", mediaType)
+	}
+	return registry.Client.Do(req)
+}
@@ -0,0 +1,82 @@
+package registry
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+)
+
+func TestHasManifest(t *testing.T) {
+	for _, disallowHead := range []bool{false, true} {
+		fake, r := newFakeRegistry(t)
+		fake.disallowManifestHead = disallowHead
+		config := `{"architecture":"amd64","os":"linux"}`
+		d := pushTestImage(t, r, "test/image", config)
+
+		status, err := r.HasManifest("test/image", digest.FromString(config).Encoded()[:16])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !status.Exists || status.Digest != d || status.MediaType != MediaTypeImageManifest {
+			t.Errorf("Unexpected status (HEAD disallowed: %v): %+v", disallowHead, status)
+		}
+
+		status, err = r.HasManifest("test/image", "missing")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Exists {
+			t.Errorf("Expected a missing tag not to exist (HEAD disallowed: %v)", disallowHead)
+		}
+	}
+}
+
+func TestHasManifests(t *testing.T) {
+	_, r := newFakeRegistry(t)
+	d := pushTestImage(t, r, "test/image", `{"architecture":"amd64","os":"linux"}`)
+
+	references := []string{"missing", d.String(), fakeDigest.String()}
+	statuses, err := r.HasManifests("test/image", references)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != len(references) {
+		t.Fatalf("Expected %d statuses but got %d", len(references), len(statuses))
+	}
+	for i, exists := range []bool{false, true, false} {
+		if statuses[i].Reference != references[i] || statuses[i].Exists != exists {
+			t.Errorf("Unexpected status for %s: %+v", references[i], statuses[i])
+		}
+	}
+}
+
+func TestHasManifestWithoutResponseRequest(t *testing.T) {
+	payload := `{"schemaVersion":2}`
+	// Transports are not required to set Response.Request.
+	transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Method == http.MethodHead {
+			return &http.Response{StatusCode: http.StatusMethodNotAllowed, Header: http.Header{}, Body: http.NoBody}, nil
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {MediaTypeImageManifest + "; charset=utf-8"}},
+			Body:       io.NopCloser(strings.NewReader(payload)),
+		}, nil
+	})
+	r := &Registry{
+		URL:    "https://registry.example.com",
+		Client: &http.Client{Transport: WrapTransport(transport, "https://registry.example.com", "", "")},
+		Logf:   Quiet,
+	}
+
+	status, err := r.HasManifest("test/image", "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Exists || status.Digest != digest.FromString(payload) || status.MediaType != MediaTypeImageManifest {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+}
@@ -0,0 +1,220 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/opencontainers/go-digest"
+)
+
+type fakeManifest struct {
+	mediaType string
+	payload   []byte
+}
+
+// fakeRegistry is a minimal in-memory implementation of the distribution API
+// used to exercise the push and pull paths of the client.
+type fakeRegistry struct {
+	mu        sync.Mutex
+	blobs     map[digest.Digest][]byte
+	manifests map[digest.Digest]fakeManifest
+	tags      map[string]digest.Digest
+
+	// disallowManifestHead answers HEAD requests for manifests with 405.
+	disallowManifestHead bool
+	// fullBlobReads counts GET requests for blobs without a Range header.
+	fullBlobReads int
+	// referrersStatus, if set, is the status the referrers API answers with.
+	// Otherwise it is unsupported and answers with 404.
+	referrersStatus int
+	// referrersRequests counts requests to the referrers API.
+	referrersRequests int
+	// truncateBlobReads is the number of blob GET requests whose response
+	// body is cut off halfway, dropping the connection.
+	truncateBlobReads int
+}
+
+func newFakeRegistry(t *testing.T) (*fakeRegistry, *Registry) {
+	fake := &fakeRegistry{
+		blobs:     make(map[digest.Digest][]byte),
+		manifests: make(map[digest.Digest]fakeManifest),
+		tags:      make(map[string]digest.Digest),
+	}
+	s := httptest.NewServer(fake)
+	t.Cleanup(s.Close)
+
+	r, err := New(s.URL, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Logf = Quiet
+	return fake, r
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	switch {
+	case path == "":
+		w.WriteHeader(http.StatusOK)
+	case strings.Contains(path, "/blobs/uploads/"):
+		f.serveUpload(w, r, path)
+	case strings.Contains(path, "/blobs/"):
+		f.serveBlob(w, r, path[strings.LastIndex(path, "/")+1:])
+	case strings.Contains(path, "/referrers/"):
+		f.referrersRequests++
+		if f.referrersStatus == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(f.referrersStatus)
+	case strings.Contains(path, "/manifests/"):
+		f.serveManifest(w, r, path[strings.LastIndex(path, "/")+1:])
+	case strings.HasSuffix(path, "/tags/list"):
+		tags := make([]string, 0, len(f.tags))
+		for tag := range f.tags {
+			tags = append(tags, tag)
+		}
+		sort.Strings(tags)
+		_ = json.NewEncoder(w).Encode(tagsResponse{Tags: tags})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeRegistry) serveUpload(w http.ResponseWriter, r *http.Request, path string) {
+	switch r.Method {
+	case http.MethodPost:
+		w.Header().Set("Location", "/v2/"+path+"session")
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		d := digest.Digest(r.URL.Query().Get("digest"))
+		if digest.FromBytes(body) != d {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.blobs[d] = body
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeRegistry) serveBlob(w http.ResponseWriter, r *http.Request, reference string) {
+	blob, ok := f.blobs[digest.Digest(reference)]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method == http.MethodGet && r.Header.Get("Range") == "" {
+		f.fullBlobReads++
+	}
+	w.Header().Set("Docker-Content-Digest", reference)
+	if r.Method == http.MethodGet && f.truncateBlobReads > 0 {
+		f.truncateBlobReads--
+		w = &truncatingWriter{ResponseWriter: w, remaining: int64(len(blob)) / 8}
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(blob))
+}
+
+// truncatingWriter fails once remaining bytes have been written, so that the
+// server drops the connection short of the announced Content-Length.
+type truncatingWriter struct {
+	http.ResponseWriter
+	remaining int64
+}
+
+func (w *truncatingWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > w.remaining {
+		n, _ := w.ResponseWriter.Write(p[:w.remaining])
+		w.remaining = 0
+		return n, io.ErrShortWrite
+	}
+	w.remaining -= int64(len(p))
+	return w.ResponseWriter.Write(p)
+}
+
+func (f *fakeRegistry) serveManifest(w http.ResponseWriter, r *http.Request, reference string) {
+	d := digest.Digest(reference)
+	if tagged, ok := f.tags[reference]; ok {
+		d = tagged
+	}
+
+	if r.Method == http.MethodHead && f.disallowManifestHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		m, ok := f.manifests[d]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", m.mediaType)
+		w.Header().Set("Docker-Content-Digest", d.String())
+		w.Header().Set("Content-Length", fmt.Sprint(len(m.payload)))
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(m.payload)
+		}
+	case http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		d = digest.FromBytes(body)
+		f.manifests[d] = fakeManifest{mediaType: r.Header.Get("Content-Type"), payload: body}
+		if d.String() != reference {
+			f.tags[reference] = d
+		}
+		w.Header().Set("Docker-Content-Digest", d.String())
+		w.Header().Set("Location", r.URL.Path)
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		if _, ok := f.tags[reference]; ok {
+			delete(f.tags, reference)
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		if _, ok := f.manifests[d]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.manifests, d)
+		for tag, tagged := range f.tags {
+			if tagged == d {
+				delete(f.tags, tag)
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeRegistry) descriptor(d digest.Digest) distribution.Descriptor {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	m := f.manifests[d]
+	return distribution.Descriptor{MediaType: m.mediaType, Size: int64(len(m.payload)), Digest: d}
+}
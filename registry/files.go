@@ -0,0 +1,199 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/distribution"
+	"github.com/opencontainers/go-digest"
+)
+
+// MediaTypeFile is the media type files are pushed with by PushFiles unless
+// another one is given, as ORAS does.
+const MediaTypeFile = "application/vnd.oci.image.layer.v1.tar"
+
+// ArtifactFile is a file on disk to be pushed as a layer of an artifact.
+type ArtifactFile struct {
+	// Path of the file to read.
+	Path string
+	// Title is the name the file is pulled back as, recorded in the
+	// AnnotationTitle annotation. It defaults to the base name of Path.
+	Title string
+	// MediaType of the layer. It defaults to MediaTypeFile.
+	MediaType string
+	// Annotations are set on the layer in addition to the title.
+	Annotations map[string]string
+}
+
+// PulledFile is a file written by PullFiles.
+type PulledFile struct {
+	// Path the file was written to.
+	Path string
+	// Descriptor of the layer the file was read from.
+	Descriptor distribution.Descriptor
+}
+
+// PushFiles pushes the given files as the layers of an artifact, following
+// the blobs of artifact, in the layout ORAS uses: each layer carries the
+// file name in its AnnotationTitle annotation. The files are streamed from
+// disk, so they may be larger than memory. An empty reference pushes the
+// manifest by digest only.
+func (registry *Registry) PushFiles(repository, reference string, artifact Artifact, files []ArtifactFile) (PushedManifest, error) {
+	titles := make(map[string]bool, len(files))
+	layers := make([]distribution.Descriptor, 0, len(files))
+	for _, file := range files {
+		title := file.Title
+		if title == "" {
+			title = filepath.Base(file.Path)
+		}
+		if !isLocalPath(title) {
+			return PushedManifest{}, fmt.Errorf("file title %q is not a local path", title)
+		}
+		if titles[title] {
+			return PushedManifest{}, fmt.Errorf("duplicate file title %q", title)
+		}
+		titles[title] = true
+
+		descriptor, err := registry.uploadFile(repository, file.Path)
+		if err != nil {
+			return PushedManifest{}, err
+		}
+		descriptor.MediaType = file.MediaType
+		if descriptor.MediaType == "" {
+			descriptor.MediaType = MediaTypeFile
+		}
+		descriptor.Annotations = make(map[string]string, len(file.Annotations)+1)
+		for key, value := range file.Annotations {
+			descriptor.Annotations[key] = value
+		}
+		descriptor.Annotations[AnnotationTitle] = filepath.ToSlash(title)
+		layers = append(layers, descriptor)
+	}
+
+	return registry.pushArtifact(repository, reference, artifact, layers)
+}
+
+// uploadFile uploads the file at the given path unless the repository
+// already has it, reading it once to digest it and once to upload it.
+func (registry *Registry) uploadFile(repository, path string) (distribution.Descriptor, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	defer file.Close()
+
+	digester := digest.Canonical.Digester()
+	size, err := io.Copy(digester.Hash(), file)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	descriptor := distribution.Descriptor{Size: size, Digest: digester.Digest()}
+
+	exists, err := registry.HasLayer(repository, descriptor.Digest)
+	if err != nil || exists {
+		return descriptor, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return distribution.Descriptor{}, err
+	}
+	return descriptor, registry.UploadLayer(repository, descriptor.Digest, file)
+}
+
+// PullFiles downloads the layers of the artifact with the given reference
+// which carry an AnnotationTitle annotation into dir, under their titles.
+// Titles must be local paths, so that nothing is written outside dir, and
+// each file is only moved into place once its digest has been verified.
+// Existing files are overwritten.
+func (registry *Registry) PullFiles(repository, reference, dir string) ([]PulledFile, error) {
+	payload, _, err := registry.fetchManifest(repository, reference, MediaTypeImageManifest)
+	if err != nil {
+		return nil, err
+	}
+	if d, err := digest.Parse(reference); err == nil {
+		if actual := d.Algorithm().FromBytes(payload); actual != d {
+			return nil, fmt.Errorf("%w: manifest %s has digest %s", ErrDigestMismatch, d, actual)
+		}
+	}
+	var artifact ArtifactManifest
+	if err := json.Unmarshal(payload, &artifact); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %w", reference, err)
+	}
+
+	// Check every title before writing anything.
+	titles := make(map[string]bool)
+	for _, layer := range artifact.Layers {
+		title, ok := layer.Annotations[AnnotationTitle]
+		if !ok {
+			continue
+		}
+		local := filepath.FromSlash(title)
+		if !isLocalPath(local) {
+			return nil, fmt.Errorf("layer %s has unsafe title %q", layer.Digest, title)
+		}
+		if titles[filepath.Clean(local)] {
+			return nil, fmt.Errorf("duplicate file title %q", title)
+		}
+		titles[filepath.Clean(local)] = true
+	}
+
+	var pulled []PulledFile
+	for _, layer := range artifact.Layers {
+		title, ok := layer.Annotations[AnnotationTitle]
+		if !ok {
+			continue
+		}
+		path := filepath.Join(dir, filepath.FromSlash(title))
+		if err := registry.pullFile(repository, layer, path); err != nil {
+			return pulled, err
+		}
+		pulled = append(pulled, PulledFile{Path: path, Descriptor: layer})
+	}
+	return pulled, nil
+}
+
+// pullFile downloads a blob to a temporary file next to path and renames it
+// into place once the download has been verified.
+func (registry *Registry) pullFile(repository string, descriptor distribution.Descriptor, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	blob, err := registry.DownloadBlob(repository, descriptor)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	file, err := os.CreateTemp(filepath.Dir(path), ".pull-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := io.Copy(file, blob); err != nil {
+		file.Close()
+		return fmt.Errorf("pulling %s: %w", filepath.Base(path), err)
+	}
+	if err := file.Chmod(0644); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// isLocalPath reports whether path, using the separators of the OS, is
+// relative and stays within the directory it is resolved against, like
+// filepath.IsLocal.
+func isLocalPath(path string) bool {
+	if path == "" || filepath.IsAbs(path) || filepath.VolumeName(path) != "" {
+		return false
+	}
+	clean := filepath.Clean(path)
+	return clean != ".." && !strings.HasPrefix(clean, ".."+string(filepath.Separator)) && !strings.HasPrefix(clean, string(filepath.Separator))
+}
@@ -0,0 +1,100 @@
+package registry
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestPushAndPullFiles(t *testing.T) {
+	fake, r := newFakeRegistry(t)
+
+	source := t.TempDir()
+	if err := os.WriteFile(filepath.Join(source, "module.wasm"), []byte("\x00asm"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(source, "policy.rego"), []byte("package main"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pushed, err := r.PushFiles("test/bundle", "v1", Artifact{ArtifactType: "application/vnd.example.bundle"}, []ArtifactFile{
+		{Path: filepath.Join(source, "module.wasm"), MediaType: "application/vnd.wasm.content.layer.v1+wasm"},
+		{Path: filepath.Join(source, "policy.rego"), Title: "policies/main.rego"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.blobs) != 3 {
+		t.Errorf("Expected the config and two files to be uploaded but got %d blobs", len(fake.blobs))
+	}
+
+	target := t.TempDir()
+	pulled, err := r.PullFiles("test/bundle", pushed.Digest.String(), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pulled) != 2 {
+		t.Fatalf("Expected two files but got: %+v", pulled)
+	}
+	for path, expected := range map[string]string{
+		"module.wasm":        "\x00asm",
+		"policies/main.rego": "package main",
+	} {
+		content, err := os.ReadFile(filepath.Join(target, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != expected {
+			t.Errorf("Expected %q in %s but got: %q", expected, path, content)
+		}
+	}
+	if pulled[1].Descriptor.MediaType != MediaTypeFile {
+		t.Errorf("Expected the default media type but got: %s", pulled[1].Descriptor.MediaType)
+	}
+
+	if _, err := r.PushFiles("test/bundle", "v2", Artifact{ArtifactType: "application/vnd.example.bundle"}, []ArtifactFile{
+		{Path: filepath.Join(source, "policy.rego"), Title: "../policy.rego"},
+	}); err == nil {
+		t.Error("Expected an error for a title outside the directory")
+	}
+}
+
+func TestPullFilesRejectsUnsafeTitles(t *testing.T) {
+	fake, r := newFakeRegistry(t)
+	content := []byte("root:x:0:0")
+	d := digest.FromBytes(content)
+	fake.blobs[d] = content
+	fake.blobs[EmptyJSONDescriptor.Digest] = emptyJSON
+
+	for _, title := range []string{"../../etc/passwd", "/etc/passwd"} {
+		m, err := ArtifactManifestFromStruct(ArtifactManifest{
+			Versioned:    manifest.Versioned{SchemaVersion: 2, MediaType: MediaTypeImageManifest},
+			ArtifactType: "application/vnd.example.bundle",
+			Config:       EmptyJSONDescriptor,
+			Layers: []distribution.Descriptor{{
+				MediaType:   MediaTypeFile,
+				Size:        int64(len(content)),
+				Digest:      d,
+				Annotations: map[string]string{AnnotationTitle: title},
+			}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.PutManifestWithDescriptor("test/bundle", "evil", m, PutManifestOptions{}); err != nil {
+			t.Fatal(err)
+		}
+
+		target := t.TempDir()
+		if _, err := r.PullFiles("test/bundle", "evil", filepath.Join(target, "out")); err == nil {
+			t.Errorf("Expected an error for the title %q", title)
+		}
+		if entries, _ := os.ReadDir(target); len(entries) != 0 {
+			t.Errorf("Expected nothing to be written for the title %q", title)
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package registry
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/distribution"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// MediaTypeHelmConfig specifies the media type of Helm chart configs,
+	// which hold the chart metadata as JSON.
+	MediaTypeHelmConfig = "application/vnd.cncf.helm.config.v1+json"
+	// MediaTypeHelmChartContent specifies the media type of packaged Helm charts.
+	MediaTypeHelmChartContent = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+	// MediaTypeHelmProvenance specifies the media type of Helm chart provenance files.
+	MediaTypeHelmProvenance = "application/vnd.cncf.helm.chart.provenance.v1.prov"
+)
+
+// ChartMaintainer is a maintainer listed in a Chart.yaml.
+type ChartMaintainer struct {
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+	URL   string `json:"url,omitempty"`
+}
+
+// ChartDependency is a dependency listed in a Chart.yaml.
+type ChartDependency struct {
+	Name         string        `json:"name"`
+	Version      string        `json:"version,omitempty"`
+	Repository   string        `json:"repository"`
+	Condition    string        `json:"condition,omitempty"`
+	Tags         []string      `json:"tags,omitempty"`
+	Enabled      bool          `json:"enabled,omitempty"`
+	ImportValues []interface{} `json:"import-values,omitempty"`
+	Alias        string        `json:"alias,omitempty"`
+}
+
+// ChartMetadata is the content of a Chart.yaml, which Helm stores as JSON in
+// the config blob of a chart.
+type ChartMetadata struct {
+	Name         string             `json:"name,omitempty"`
+	Home         string             `json:"home,omitempty"`
+	Sources      []string           `json:"sources,omitempty"`
+	Version      string             `json:"version,omitempty"`
+	Description  string             `json:"description,omitempty"`
+	Keywords     []string           `json:"keywords,omitempty"`
+	Maintainers  []*ChartMaintainer `json:"maintainers,omitempty"`
+	Icon         string             `json:"icon,omitempty"`
+	APIVersion   string             `json:"apiVersion,omitempty"`
+	Condition    string             `json:"condition,omitempty"`
+	Tags         string             `json:"tags,omitempty"`
+	AppVersion   string             `json:"appVersion,omitempty"`
+	Deprecated   bool               `json:"deprecated,omitempty"`
+	Annotations  map[string]string  `json:"annotations,omitempty"`
+	KubeVersion  string             `json:"kubeVersion,omitempty"`
+	Dependencies []*ChartDependency `json:"dependencies,omitempty"`
+	Type         string             `json:"type,omitempty"`
+}
+
+// ChartTag returns the tag Helm pushes the given chart version under. Tags
+// cannot contain "+", so Helm replaces it with "_".
+func ChartTag(version string) string {
+	return strings.ReplaceAll(version, "+", "_")
+}
+
+// ChartVersions returns the versions of the chart stored in repository, in
+// ascending semantic version order. Tags which are not semantic versions,
+// such as those of signatures, are skipped.
+func (registry *Registry) ChartVersions(repository string) ([]string, error) {
+	tags, err := registry.Tags(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []semver
+	for _, tag := range tags {
+		if version, ok := parseSemver(strings.ReplaceAll(tag, "_", "+")); ok {
+			versions = append(versions, version)
+		}
+	}
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].compare(versions[j]) < 0
+	})
+
+	result := make([]string, len(versions))
+	for i, version := range versions {
+		result[i] = version.original
+	}
+	return result, nil
+}
+
+// ChartMetadata returns the metadata of the given chart version, read from
+// the config blob of the chart.
+func (registry *Registry) ChartMetadata(repository, version string) (ChartMetadata, error) {
+	chart, err := registry.chartManifest(repository, version)
+	if err != nil {
+		return ChartMetadata{}, err
+	}
+	config, err := registry.fetchBlob(repository, chart.Config)
+	if err != nil {
+		return ChartMetadata{}, err
+	}
+	var metadata ChartMetadata
+	if err := json.Unmarshal(config, &metadata); err != nil {
+		return ChartMetadata{}, fmt.Errorf("invalid chart config %s: %w", chart.Config.Digest, err)
+	}
+	return metadata, nil
+}
+
+// DownloadChart downloads the packaged chart (.tgz) of the given chart
+// version. The returned reader verifies the digest of the chart as with
+// DownloadBlob.
+func (registry *Registry) DownloadChart(repository, version string) (io.ReadCloser, distribution.Descriptor, error) {
+	chart, err := registry.chartManifest(repository, version)
+	if err != nil {
+		return nil, distribution.Descriptor{}, err
+	}
+	for _, layer := range chart.Layers {
+		if layer.MediaType == MediaTypeHelmChartContent {
+			blob, err := registry.DownloadBlob(repository, layer)
+			return blob, layer, err
+		}
+	}
+	return nil, distribution.Descriptor{}, fmt.Errorf("chart %s:%s has no %s layer", repository, version, MediaTypeHelmChartContent)
+}
+
+func (registry *Registry) chartManifest(repository, version string) (ArtifactManifest, error) {
+	payload, _, err := registry.fetchManifest(repository, ChartTag(version), MediaTypeImageManifest)
+	if err != nil {
+		return ArtifactManifest{}, err
+	}
+	var chart ArtifactManifest
+	if err := json.Unmarshal(payload, &chart); err != nil {
+		return ArtifactManifest{}, fmt.Errorf("invalid chart manifest %s:%s: %w", repository, version, err)
+	}
+	if chart.Config.MediaType != MediaTypeHelmConfig {
+		return ArtifactManifest{}, fmt.Errorf("%s:%s is not a Helm chart: config has media type %q", repository, version, chart.Config.MediaType)
+	}
+	return chart, nil
+}
+
+// PushChart pushes a packaged chart (.tgz), and its provenance file if
+// provenance is not nil, the way helm push does. The chart metadata is read
+// from the Chart.yaml in the package and the chart is tagged with its
+// version. The repository should end with the chart name.
+func (registry *Registry) PushChart(repository string, chart, provenance []byte) (PushedManifest, error) {
+	metadata, err := chartMetadataFromPackage(chart)
+	if err != nil {
+		return PushedManifest{}, err
+	}
+	if metadata.Name == "" || metadata.Version == "" {
+		return PushedManifest{}, errors.New("chart name and version must be set in Chart.yaml")
+	}
+	if _, ok := parseSemver(metadata.Version); !ok {
+		return PushedManifest{}, fmt.Errorf("chart version %q is not a semantic version", metadata.Version)
+	}
+	config, err := json.Marshal(metadata)
+	if err != nil {
+		return PushedManifest{}, err
+	}
+
+	blobs := []ArtifactBlob{{MediaType: MediaTypeHelmChartContent, Content: chart}}
+	if provenance != nil {
+		blobs = append(blobs, ArtifactBlob{MediaType: MediaTypeHelmProvenance, Content: provenance})
+	}
+	return registry.PushArtifact(repository, ChartTag(metadata.Version), Artifact{
+		ConfigMediaType: MediaTypeHelmConfig,
+		Config:          config,
+		Blobs:           blobs,
+		Annotations:     chartAnnotations(metadata),
+	})
+}
+
+// chartAnnotations returns the manifest annotations helm push sets for a chart.
+func chartAnnotations(metadata ChartMetadata) map[string]string {
+	annotations := make(map[string]string, len(metadata.Annotations)+6)
+	for key, value := range metadata.Annotations {
+		annotations[key] = value
+	}
+	annotations[AnnotationTitle] = metadata.Name
+	annotations[AnnotationVersion] = metadata.Version
+	if metadata.Description != "" {
+		annotations[AnnotationDescription] = metadata.Description
+	}
+	if metadata.Home != "" {
+		annotations[AnnotationURL] = metadata.Home
+	}
+	if len(metadata.Sources) > 0 {
+		annotations[AnnotationSource] = metadata.Sources[0]
+	}
+	var authors []string
+	for _, maintainer := range metadata.Maintainers {
+		if maintainer == nil || maintainer.Name == "" {
+			continue
+		}
+		if maintainer.Email != "" {
+			authors = append(authors, fmt.Sprintf("%s (%s)", maintainer.Name, maintainer.Email))
+		} else {
+			authors = append(authors, maintainer.Name)
+		}
+	}
+	if len(authors) > 0 {
+		annotations[AnnotationAuthors] = strings.Join(authors, ", ")
+	}
+	return annotations
+}
+
+// chartMetadataFromPackage reads the Chart.yaml at the top of a packaged chart.
+func chartMetadataFromPackage(chart []byte) (ChartMetadata, error) {
+	gzipReader, err := gzip.NewReader(bytes.NewReader(chart))
+	if err != nil {
+		return ChartMetadata{}, fmt.Errorf("invalid chart package: %w", err)
+	}
+	defer gzipReader.Close()
+
+	reader := tar.NewReader(gzipReader)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return ChartMetadata{}, errors.New("invalid chart package: no Chart.yaml found")
+		}
+		if err != nil {
+			return ChartMetadata{}, fmt.Errorf("invalid chart package: %w", err)
+		}
+		// Chart.yaml is at <chart name>/Chart.yaml; those of subcharts are deeper.
+		name := strings.TrimPrefix(path.Clean("/"+header.Name), "/")
+		if header.Typeflag != tar.TypeReg || path.Base(name) != "Chart.yaml" || strings.Count(name, "/") != 1 {
+			continue
+		}
+		content, err := readLimited(reader, 1<<20, "Chart.yaml")
+		if err != nil {
+			return ChartMetadata{}, err
+		}
+		var metadata ChartMetadata
+		if err := yaml.Unmarshal(content, &metadata); err != nil {
+			return ChartMetadata{}, fmt.Errorf("invalid Chart.yaml: %w", err)
+		}
+		return metadata, nil
+	}
+}
+
+// semver is a parsed semantic version, as Helm requires chart versions to be.
+type semver struct {
+	original            string
+	major, minor, patch uint64
+	prerelease          []string
+}
+
+var semverPattern = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(?:-([0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*))?(?:\+[0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*)?$`)
+
+func parseSemver(version string) (semver, bool) {
+	match := semverPattern.FindStringSubmatch(version)
+	if match == nil {
+		return semver{}, false
+	}
+	parsed := semver{original: version}
+	var err error
+	if parsed.major, err = strconv.ParseUint(match[1], 10, 64); err != nil {
+		return semver{}, false
+	}
+	if parsed.minor, err = strconv.ParseUint(match[2], 10, 64); err != nil {
+		return semver{}, false
+	}
+	if parsed.patch, err = strconv.ParseUint(match[3], 10, 64); err != nil {
+		return semver{}, false
+	}
+	if match[4] != "" {
+		parsed.prerelease = strings.Split(match[4], ".")
+	}
+	return parsed, true
+}
+
+// compare orders versions by semantic version precedence; build metadata is
+// ignored.
+func (v semver) compare(other semver) int {
+	for _, pair := range [][2]uint64{{v.major, other.major}, {v.minor, other.minor}, {v.patch, other.patch}} {
+		if pair[0] != pair[1] {
+			if pair[0] < pair[1] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	// A version without a prerelease has higher precedence than one with.
+	switch {
+	case len(v.prerelease) == 0 && len(other.prerelease) == 0:
+		return 0
+	case len(v.prerelease) == 0:
+		return 1
+	case len(other.prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.prerelease) && i < len(other.prerelease); i++ {
+		if c := comparePrerelease(v.prerelease[i], other.prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	return len(v.prerelease) - len(other.prerelease)
+}
+
+// comparePrerelease compares prerelease identifiers: numeric ones
+// numerically and below alphanumeric ones, which are compared lexically.
+func comparePrerelease(a, b string) int {
+	aNumber, aErr := strconv.ParseUint(a, 10, 64)
+	bNumber, bErr := strconv.ParseUint(b, 10, 64)
+	switch {
+	case aErr == nil && bErr == nil:
+		if aNumber == bNumber {
+			return 0
+		}
+		if aNumber < bNumber {
+			return -1
+		}
+		return 1
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
@@ -0,0 +1,98 @@
+package registry
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func packageChart(t *testing.T, files map[string]string) []byte {
+	var buffer bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buffer)
+	tw := tar.NewWriter(gzipWriter)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Size: int64(len(content)), Mode: 0644}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestPushAndPullChart(t *testing.T) {
+	_, r := newFakeRegistry(t)
+
+	for _, version := range []string{"1.10.0", "1.2.0+build.1", "1.2.0-rc.1", "1.2.0"} {
+		chart := packageChart(t, map[string]string{
+			"nginx/Chart.yaml": `apiVersion: v2
+name: nginx
+version: ` + version + `
+appVersion: "1.25"
+description: A web server
+maintainers:
+- name: Jane
+  email: jane@example.com
+`,
+			"nginx/charts/sub/Chart.yaml": "apiVersion: v2\nname: sub\nversion: 0.1.0\n",
+			"nginx/values.yaml":           "replicas: 1\n",
+		})
+		if _, err := r.PushChart("charts/nginx", chart, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.PutManifest("charts/nginx", "sha256-0123.sig", rawManifest{mediaType: MediaTypeImageManifest, payload: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err := r.ChartVersions("charts/nginx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"1.2.0-rc.1", "1.2.0", "1.2.0+build.1", "1.10.0"}; !reflect.DeepEqual(versions, expected) {
+		t.Errorf("Expected versions %v but got: %v", expected, versions)
+	}
+
+	metadata, err := r.ChartMetadata("charts/nginx", "1.2.0+build.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Name != "nginx" || metadata.Version != "1.2.0+build.1" || metadata.AppVersion != "1.25" ||
+		len(metadata.Maintainers) != 1 || metadata.Maintainers[0].Email != "jane@example.com" {
+		t.Errorf("Unexpected chart metadata: %+v", metadata)
+	}
+
+	chart, descriptor, err := r.DownloadChart("charts/nginx", "1.10.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chart.Close()
+	content, err := io.ReadAll(chart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if descriptor.MediaType != MediaTypeHelmChartContent || int64(len(content)) != descriptor.Size {
+		t.Errorf("Unexpected chart descriptor: %+v", descriptor)
+	}
+	if metadata, err := chartMetadataFromPackage(content); err != nil || metadata.Version != "1.10.0" {
+		t.Errorf("Unexpected downloaded chart: %+v, %v", metadata, err)
+	}
+}
+
+func TestPushChartRequiresVersion(t *testing.T) {
+	_, r := newFakeRegistry(t)
+	chart := packageChart(t, map[string]string{"nginx/Chart.yaml": "apiVersion: v2\nname: nginx\nversion: latest\n"})
+	if _, err := r.PushChart("charts/nginx", chart, nil); err == nil {
+		t.Error("Expected an error for a version which is not a semantic version")
+	}
+}
@@ -0,0 +1,233 @@
+package registry
+
+import (
+	_ "crypto/sha512" // registers SHA-512 for manifests referenced by sha512 digests
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/opencontainers/go-digest"
+)
+
+// Index is an OCI image index or Docker manifest list. Unlike
+// manifestlist.ManifestList it carries index level annotations.
+type Index struct {
+	manifest.Versioned
+
+	// Manifests references the platform specific manifests.
+	Manifests []manifestlist.ManifestDescriptor `json:"manifests"`
+
+	// Annotations contains arbitrary metadata for the index.
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// References returns the descriptors of the manifests in the index.
+func (m Index) References() []distribution.Descriptor {
+	references := make([]distribution.Descriptor, len(m.Manifests))
+	for i := range m.Manifests {
+		references[i] = m.Manifests[i].Descriptor
+	}
+	return references
+}
+
+// DeserializedIndex wraps Index with the exact bytes it was serialized to.
+type DeserializedIndex struct {
+	Index
+
+	canonical []byte
+}
+
+var _ distribution.Manifest = &DeserializedIndex{}
+
+// IndexFromStruct marshals the given index and returns it along with its
+// JSON representation.
+func IndexFromStruct(index Index) (*DeserializedIndex, error) {
+	canonical, err := json.MarshalIndent(&index, "", "   ")
+	if err != nil {
+		return nil, err
+	}
+	return &DeserializedIndex{
+		Index:     index,
+		canonical: canonical,
+	}, nil
+}
+
+// UnmarshalJSON populates the index from JSON data, keeping a copy of it.
+func (m *DeserializedIndex) UnmarshalJSON(b []byte) error {
+	m.canonical = make([]byte, len(b))
+	copy(m.canonical, b)
+
+	var index Index
+	if err := json.Unmarshal(m.canonical, &index); err != nil {
+		return err
+	}
+	m.Index = index
+	return nil
+}
+
+// MarshalJSON returns the JSON representation of the index.
+func (m *DeserializedIndex) MarshalJSON() ([]byte, error) {
+	if len(m.canonical) > 0 {
+		return m.canonical, nil
+	}
+	return nil, errors.New("JSON representation not initialized in DeserializedIndex")
+}
+
+// Payload returns the media type and raw content of the index.
+func (m DeserializedIndex) Payload() (string, []byte, error) {
+	mediaType := m.MediaType
+	if mediaType == "" {
+		mediaType = MediaTypeImageIndex
+	}
+	return mediaType, m.canonical, nil
+}
+
+// IndexEntry describes a manifest to be included in an index.
+type IndexEntry struct {
+	// Digest of a manifest which already exists in the repository.
+	Digest digest.Digest
+	// Platform of the manifest. If nil, it is read from the manifest's config.
+	Platform *manifestlist.PlatformSpec
+	// Annotations to set on the index entry.
+	Annotations map[string]string
+}
+
+// imageConfig holds the parts of an image configuration this library looks at.
+type imageConfig struct {
+	Architecture string   `json:"architecture"`
+	OS           string   `json:"os"`
+	OSVersion    string   `json:"os.version,omitempty"`
+	OSFeatures   []string `json:"os.features,omitempty"`
+	Variant      string   `json:"variant,omitempty"`
+}
+
+// manifestDocument holds the fields shared by manifests and indexes which are
+// needed to tell them apart and to follow their references.
+type manifestDocument struct {
+	MediaType string                    `json:"mediaType"`
+	Config    *distribution.Descriptor  `json:"config"`
+	Layers    []distribution.Descriptor `json:"layers"`
+	Manifests []distribution.Descriptor `json:"manifests"`
+}
+
+// BuildIndex assembles an index of the given media type, which must be either
+// MediaTypeImageIndex or manifestlist.MediaTypeManifestList, from manifests
+// already present in the repository. Entries without a platform have it
+// filled in from the config of the referenced manifest.
+func (registry *Registry) BuildIndex(repository, mediaType string, entries []IndexEntry, annotations map[string]string) (*DeserializedIndex, error) {
+	switch mediaType {
+	case MediaTypeImageIndex:
+	case manifestlist.MediaTypeManifestList:
+		if len(annotations) > 0 {
+			return nil, errors.New("annotations are not supported by Docker manifest lists")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported index media type %q", mediaType)
+	}
+
+	manifests := make([]manifestlist.ManifestDescriptor, 0, len(entries))
+	for _, entry := range entries {
+		descriptor, err := registry.indexDescriptor(repository, entry)
+		if err != nil {
+			return nil, err
+		}
+		if mediaType == manifestlist.MediaTypeManifestList && len(descriptor.Annotations) > 0 {
+			return nil, errors.New("annotations are not supported by Docker manifest lists")
+		}
+		manifests = append(manifests, descriptor)
+	}
+
+	return IndexFromStruct(Index{
+		Versioned: manifest.Versioned{
+			SchemaVersion: 2,
+			MediaType:     mediaType,
+		},
+		Manifests:   manifests,
+		Annotations: annotations,
+	})
+}
+
+// PushIndex builds an index as with BuildIndex and pushes it under the given
+// tag, returning the digest of the index.
+func (registry *Registry) PushIndex(repository, tag, mediaType string, entries []IndexEntry, annotations map[string]string) (digest.Digest, error) {
+	index, err := registry.BuildIndex(repository, mediaType, entries, annotations)
+	if err != nil {
+		return "", err
+	}
+	pushed, err := registry.PutManifestWithDescriptor(repository, tag, index, PutManifestOptions{})
+	if err != nil {
+		return "", err
+	}
+	return pushed.Digest, nil
+}
+
+func (registry *Registry) indexDescriptor(repository string, entry IndexEntry) (manifestlist.ManifestDescriptor, error) {
+	if err := entry.Digest.Validate(); err != nil {
+		return manifestlist.ManifestDescriptor{}, fmt.Errorf("invalid manifest digest %v: %w", entry.Digest, err)
+	}
+
+	payload, contentType, err := registry.fetchManifest(repository, entry.Digest.String(), manifestMediaTypes...)
+	if err != nil {
+		return manifestlist.ManifestDescriptor{}, err
+	}
+	if actual := entry.Digest.Algorithm().FromBytes(payload); actual != entry.Digest {
+		return manifestlist.ManifestDescriptor{}, fmt.Errorf("%w: manifest %s has digest %s", ErrDigestMismatch, entry.Digest, actual)
+	}
+
+	var document manifestDocument
+	if err := json.Unmarshal(payload, &document); err != nil {
+		return manifestlist.ManifestDescriptor{}, err
+	}
+	mediaType := document.MediaType
+	if parsed, _, err := mime.ParseMediaType(contentType); err == nil && parsed != "" {
+		mediaType = parsed
+	}
+
+	platform := entry.Platform
+	if platform == nil {
+		if document.Config == nil {
+			return manifestlist.ManifestDescriptor{}, fmt.Errorf("manifest %s has no config to read the platform from", entry.Digest)
+		}
+		platform, err = registry.configPlatform(repository, *document.Config)
+		if err != nil {
+			return manifestlist.ManifestDescriptor{}, err
+		}
+	}
+
+	return manifestlist.ManifestDescriptor{
+		Descriptor: distribution.Descriptor{
+			MediaType:   mediaType,
+			Size:        int64(len(payload)),
+			Digest:      entry.Digest,
+			Annotations: entry.Annotations,
+		},
+		Platform: *platform,
+	}, nil
+}
+
+func (registry *Registry) configPlatform(repository string, config distribution.Descriptor) (*manifestlist.PlatformSpec, error) {
+	body, err := registry.fetchBlob(repository, config)
+	if err != nil {
+		return nil, err
+	}
+
+	var image imageConfig
+	if err := json.Unmarshal(body, &image); err != nil {
+		return nil, fmt.Errorf("invalid image config %s: %w", config.Digest, err)
+	}
+	if image.OS == "" || image.Architecture == "" {
+		return nil, fmt.Errorf("image config %s does not specify a platform", config.Digest)
+	}
+
+	return &manifestlist.PlatformSpec{
+		Architecture: image.Architecture,
+		OS:           image.OS,
+		OSVersion:    image.OSVersion,
+		OSFeatures:   image.OSFeatures,
+		Variant:      image.Variant,
+	}, nil
+}
@@ -0,0 +1,79 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/opencontainers/go-digest"
+)
+
+func pushTestImage(t *testing.T, r *Registry, repository, config string) digest.Digest {
+	b, err := r.NewManifestBuilder(repository, MediaTypeImageManifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.SetConfig("", []byte(config))
+	d, err := b.Push(digest.FromString(config).Encoded()[:16])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestPushIndex(t *testing.T) {
+	fake, r := newFakeRegistry(t)
+
+	amd64 := pushTestImage(t, r, "test/image", `{"architecture":"amd64","os":"linux"}`)
+	arm64 := pushTestImage(t, r, "test/image", `{"architecture":"arm64","os":"linux","variant":"v8"}`)
+
+	d, err := r.PushIndex("test/image", "latest", MediaTypeImageIndex, []IndexEntry{
+		{Digest: amd64},
+		{Digest: arm64, Annotations: map[string]string{"key": "value"}},
+	}, map[string]string{"org.opencontainers.image.version": "1.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fake.tags["latest"] != d {
+		t.Fatalf("Expected tag to point at %s but got: %s", d, fake.tags["latest"])
+	}
+
+	index, err := r.ImageIndex("test/image", "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Manifests) != 2 {
+		t.Fatalf("Expected 2 manifests but got: %d", len(index.Manifests))
+	}
+	want := manifestlist.PlatformSpec{Architecture: "arm64", OS: "linux", Variant: "v8"}
+	if got := index.Manifests[1].Platform; got.Architecture != want.Architecture || got.OS != want.OS || got.Variant != want.Variant {
+		t.Errorf("Expected platform %+v but got: %+v", want, got)
+	}
+	if index.Manifests[1].Annotations["key"] != "value" {
+		t.Errorf("Expected entry annotation to be set, got: %v", index.Manifests[1].Annotations)
+	}
+	if index.Manifests[0].MediaType != MediaTypeImageManifest {
+		t.Errorf("Expected media type %q but got: %q", MediaTypeImageManifest, index.Manifests[0].MediaType)
+	}
+
+	_, err = r.BuildIndex("test/image", manifestlist.MediaTypeManifestList, []IndexEntry{{Digest: amd64}}, map[string]string{"key": "value"})
+	if err == nil {
+		t.Error("Expected annotations to be rejected for Docker manifest lists")
+	}
+}
+
+func TestBuildIndexSHA512(t *testing.T) {
+	fake, r := newFakeRegistry(t)
+	amd64 := pushTestImage(t, r, "test/image", `{"architecture":"amd64","os":"linux"}`)
+
+	m := fake.manifests[amd64]
+	sha512 := digest.SHA512.FromBytes(m.payload)
+	fake.manifests[sha512] = m
+
+	index, err := r.BuildIndex("test/image", MediaTypeImageIndex, []IndexEntry{{Digest: sha512}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index.Manifests[0].Digest != sha512 {
+		t.Errorf("Expected the entry to keep digest %s but got: %s", sha512, index.Manifests[0].Digest)
+	}
+}
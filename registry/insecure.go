@@ -0,0 +1,191 @@
+package registry
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// InsecureRegistries matches registry hosts which may be reached without
+// certificate verification and, if HTTPS is not available, over plain HTTP.
+// Entries are either host names (optionally with a port) or CIDR networks.
+type InsecureRegistries struct {
+	hosts    map[string]bool
+	networks []*net.IPNet
+}
+
+// ParseInsecureRegistries builds an InsecureRegistries from a list such as
+// []string{"localhost:5000", "registry.internal", "10.0.0.0/8"}.
+func ParseInsecureRegistries(entries []string) (*InsecureRegistries, error) {
+	insecure := &InsecureRegistries{
+		hosts: make(map[string]bool),
+	}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		entry = strings.TrimPrefix(entry, "http://")
+		entry = strings.TrimPrefix(entry, "https://")
+		entry = strings.TrimSuffix(entry, "/")
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			_, network, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid insecure registry network %q: %w", entry, err)
+			}
+			insecure.networks = append(insecure.networks, network)
+			continue
+		}
+		insecure.hosts[strings.ToLower(entry)] = true
+	}
+	return insecure, nil
+}
+
+// Contains reports whether the given host (as found in a URL, optionally
+// with a port) is an insecure registry.
+func (i *InsecureRegistries) Contains(host string) bool {
+	if i == nil {
+		return false
+	}
+	host = strings.ToLower(host)
+	if i.hosts[host] {
+		return true
+	}
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	if i.hosts[hostname] {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(hostname, "[]"))
+	if ip == nil {
+		return false
+	}
+	for _, network := range i.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// SchemeFallbackTransport sends requests for insecure registries through
+// InsecureTransport and, when the HTTPS request fails because the server does
+// not speak TLS, retries it over plain HTTP. Other failures, such as timeouts,
+// reset connections or a cancelled context, are returned as they are, so that
+// requests which may carry credentials are not resent in cleartext for them.
+// The scheme that worked is remembered per host so subsequent requests go
+// straight to it. Requests for any other host are passed to Transport
+// unchanged.
+type SchemeFallbackTransport struct {
+	Transport         http.RoundTripper
+	InsecureTransport http.RoundTripper
+	Insecure          *InsecureRegistries
+
+	schemes      map[string]string
+	schemesMutex sync.RWMutex
+}
+
+func (t *SchemeFallbackTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.Insecure.Contains(req.URL.Host) {
+		return t.Transport.RoundTrip(req)
+	}
+
+	if scheme := t.Scheme(req.URL.Host); scheme != "" && scheme != req.URL.Scheme {
+		return t.InsecureTransport.RoundTrip(withScheme(req, scheme))
+	}
+
+	resp, err := t.InsecureTransport.RoundTrip(req)
+	if err == nil {
+		t.setScheme(req.URL.Host, req.URL.Scheme)
+		return resp, nil
+	}
+	if req.URL.Scheme != "https" || req.Context().Err() != nil || !isNotTLS(err) {
+		return resp, err
+	}
+
+	// The request body has already been consumed, so only retry if it can be recreated.
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return resp, err
+	}
+	httpReq := withScheme(req, "http")
+	if req.GetBody != nil {
+		body, bodyErr := req.GetBody()
+		if bodyErr != nil {
+			return resp, err
+		}
+		httpReq.Body = body
+	}
+
+	httpResp, httpErr := t.InsecureTransport.RoundTrip(httpReq)
+	if httpErr != nil {
+		return nil, fmt.Errorf("%v (plain HTTP fallback: %v)", err, httpErr)
+	}
+	t.setScheme(req.URL.Host, "http")
+	return httpResp, nil
+}
+
+// Scheme returns the scheme last known to work for the given host, or an
+// empty string if no request to it has succeeded yet.
+func (t *SchemeFallbackTransport) Scheme(host string) string {
+	t.schemesMutex.RLock()
+	defer t.schemesMutex.RUnlock()
+
+	return t.schemes[strings.ToLower(host)]
+}
+
+func (t *SchemeFallbackTransport) setScheme(host, scheme string) {
+	t.schemesMutex.Lock()
+	defer t.schemesMutex.Unlock()
+
+	if t.schemes == nil {
+		t.schemes = make(map[string]string)
+	}
+	t.schemes[strings.ToLower(host)] = scheme
+}
+
+// isNotTLS reports whether err shows that the server answered a TLS handshake
+// with something that is not TLS, such as a plain HTTP response.
+func isNotTLS(err error) bool {
+	var recordErr tls.RecordHeaderError
+	return errors.As(err, &recordErr)
+}
+
+func withScheme(req *http.Request, scheme string) *http.Request {
+	clone := req.Clone(req.Context())
+	clone.URL.Scheme = scheme
+	return clone
+}
+
+/*
+ * Create a new Registry, as with New, which treats the given hosts and CIDR
+ * networks as insecure registries: certificate verification is skipped for
+ * them and, if they do not speak HTTPS, requests fall back to plain HTTP.
+ * A registryUrl without a scheme is assumed to be https://.
+ */
+func NewWithInsecureRegistries(registryUrl, username, password string, insecureRegistries []string) (*Registry, error) {
+	insecure, err := ParseInsecureRegistries(insecureRegistries)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(registryUrl, "://") {
+		registryUrl = "https://" + registryUrl
+	}
+
+	insecureTransport := http.DefaultTransport.(*http.Transport).Clone()
+	insecureTransport.TLSClientConfig = &tls.Config{
+		InsecureSkipVerify: true,
+	}
+	transport := &SchemeFallbackTransport{
+		Transport:         http.DefaultTransport,
+		InsecureTransport: insecureTransport,
+		Insecure:          insecure,
+	}
+
+	return newWithWrapTransport(registryUrl, username, password, transport, Log)
+}
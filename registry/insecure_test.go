@@ -0,0 +1,200 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+)
+
+func TestInsecureRegistriesContains(t *testing.T) {
+	insecure, err := ParseInsecureRegistries([]string{"localhost:5000", "registry.internal", "10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]bool{
+		"localhost:5000":         true,
+		"localhost:5001":         false,
+		"registry.internal":      true,
+		"registry.internal:8443": true,
+		"10.1.2.3:5000":          true,
+		"192.168.1.1":            false,
+		"docker.io":              false,
+	}
+	for host, want := range cases {
+		if got := insecure.Contains(host); got != want {
+			t.Errorf("Contains(%q) = %v, want %v", host, got, want)
+		}
+	}
+
+	if _, err := ParseInsecureRegistries([]string{"10.0.0.0/99"}); err == nil {
+		t.Error("Expected error for invalid CIDR")
+	}
+}
+
+func TestPlainHTTPFallback(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(s.Close)
+
+	host := strings.TrimPrefix(s.URL, "http://")
+
+	r, err := NewWithInsecureRegistries(host, "", "", []string{"127.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Logf = Quiet
+	if err := r.Ping(); err != nil {
+		t.Fatalf("Expected ping to fall back to plain HTTP: %v", err)
+	}
+
+	r, err = NewWithInsecureRegistries(host, "", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Logf = Quiet
+	if err := r.Ping(); err == nil {
+		t.Error("Expected ping to fail for a host that is not an insecure registry")
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestPlainHTTPFallbackOnlyForNonTLSServers(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(s.Close)
+	host := strings.TrimPrefix(s.URL, "http://")
+	insecure, err := ParseInsecureRegistries([]string{host})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newRegistry := func(insecureTransport http.RoundTripper) (*Registry, *SchemeFallbackTransport) {
+		fallback := &SchemeFallbackTransport{
+			Transport:         http.DefaultTransport,
+			InsecureTransport: insecureTransport,
+			Insecure:          insecure,
+		}
+		r, err := NewFromTransport("https://"+host, WrapTransport(fallback, "https://"+host, "user", "secret"), Quiet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r, fallback
+	}
+
+	r, fallback := newRegistry(http.DefaultTransport)
+	if err := r.Ping(); err != nil {
+		t.Fatalf("Expected ping to fall back to plain HTTP: %v", err)
+	}
+	if scheme := fallback.Scheme(host); scheme != "http" {
+		t.Errorf("Expected recorded scheme %q but got: %q", "http", scheme)
+	}
+
+	var plainRequests int
+	resetting := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Scheme == "http" {
+			plainRequests++
+			return http.DefaultTransport.RoundTrip(req)
+		}
+		return nil, errors.New("connection reset by peer")
+	})
+	r, _ = newRegistry(resetting)
+	if err := r.Ping(); err == nil {
+		t.Error("Expected ping to fail when the HTTPS request fails")
+	}
+	if plainRequests != 0 {
+		t.Errorf("Expected no plain HTTP request for a network error, got %d", plainRequests)
+	}
+
+	counting := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Scheme == "http" {
+			plainRequests++
+		}
+		return http.DefaultTransport.RoundTrip(req)
+	})
+	r, _ = newRegistry(counting)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://"+host+"/v2/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Client.Do(req); err == nil {
+		t.Error("Expected a cancelled request to fail")
+	}
+	if plainRequests != 0 {
+		t.Errorf("Expected no plain HTTP request for a cancelled request, got %d", plainRequests)
+	}
+}
+
+// absoluteLocationWriter makes Location headers absolute, as registries which
+// know they are served over plain HTTP do.
+type absoluteLocationWriter struct {
+	http.ResponseWriter
+	host string
+}
+
+func (w absoluteLocationWriter) WriteHeader(status int) {
+	if location := w.Header().Get("Location"); strings.HasPrefix(location, "/") {
+		w.Header().Set("Location", "http://"+w.host+location)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func TestPlainHTTPFallbackPushWithBasicAuth(t *testing.T) {
+	content := []byte("layer content")
+	d := digest.FromBytes(content)
+
+	var unauthorized []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "user" || password != "secret" {
+			unauthorized = append(unauthorized, r.Method+" "+r.URL.Path)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w = absoluteLocationWriter{ResponseWriter: w, host: r.Host}
+		switch {
+		case r.URL.Path == "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodPost && r.URL.Path == "/v2/test/image/blobs/uploads/":
+			w.Header().Set("Location", "/v2/test/image/blobs/uploads/session")
+			w.WriteHeader(http.StatusAccepted)
+		case r.Method == http.MethodPut && r.URL.Path == "/v2/test/image/blobs/uploads/session":
+			if body, err := io.ReadAll(r.Body); err != nil || digest.FromBytes(body) != d {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(s.Close)
+	host := strings.TrimPrefix(s.URL, "http://")
+
+	r, err := NewWithInsecureRegistries(host, "user", "secret", []string{"127.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Logf = Quiet
+	if err := r.UploadLayer("test/image", d, bytes.NewReader(content)); err != nil {
+		t.Fatalf("Expected the upload to succeed over plain HTTP: %v", err)
+	}
+	if len(unauthorized) != 0 {
+		t.Errorf("Expected every request to carry credentials, got unauthorized requests: %q", unauthorized)
+	}
+}
@@ -18,13 +18,11 @@ func (registry *Registry) getJson(url string, response interface{}) error {
 	}
 	defer resp.Body.Close()
 
-	decoder := json.NewDecoder(resp.Body)
-	err = decoder.Decode(response)
+	body, err := readLimited(resp.Body, registry.Limits.maxResponseSize(), "response")
 	if err != nil {
 		return err
 	}
-
-	return nil
+	return json.Unmarshal(body, response)
 }
 
 // getPaginatedJson accepts a string and a pointer, and returns the
@@ -37,11 +35,13 @@ func (registry *Registry) getPaginatedJson(url string, response interface{}) (st
 	}
 	defer resp.Body.Close()
 
-	decoder := json.NewDecoder(resp.Body)
-	err = decoder.Decode(response)
+	body, err := readLimited(resp.Body, registry.Limits.maxResponseSize(), "response")
 	if err != nil {
 		return "", err
 	}
+	if err := json.Unmarshal(body, response); err != nil {
+		return "", err
+	}
 	return getNextLink(resp)
 }
 
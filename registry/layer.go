@@ -1,6 +1,7 @@
 package registry
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,20 +11,29 @@ import (
 	"github.com/opencontainers/go-digest"
 )
 
+// DownloadLayer returns the content of the blob with the given digest. The
+// content is hashed as it is read: if it does not match the digest, or its
+// size does not match the Content-Length of the response, the final Read
+// returns an error wrapping ErrDigestMismatch instead of io.EOF.
 func (registry *Registry) DownloadLayer(repository string, digest digest.Digest) (io.ReadCloser, error) {
 	if err := digest.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid layer digest %v: %w", digest, err)
 	}
 
-	url := registry.url("/v2/%s/blobs/%s", repository, digest)
-	registry.Logf("registry.layer.download url=%s repository=%s digest=%s", url, repository, digest)
-
-	resp, err := registry.Client.Get(url)
+	resp, err := registry.downloadLayer(repository, digest)
 	if err != nil {
 		return nil, err
 	}
+	return newVerifyingReader(resp.Body, distribution.Descriptor{Digest: digest, Size: resp.ContentLength}), nil
+}
 
-	return resp.Body, nil
+func (registry *Registry) downloadLayer(repository string, digest digest.Digest) (*http.Response, error) {
+	resp, err := registry.getBlob(context.Background(), repository, digest, "")
+	if err != nil {
+		return nil, err
+	}
+	registry.resumeRedirected(repository, digest, resp)
+	return resp, nil
 }
 
 func (registry *Registry) UploadLayer(repository string, digest digest.Digest, content io.Reader) error {
@@ -66,16 +76,7 @@ func (registry *Registry) HasLayer(repository string, digest digest.Digest) (boo
 	if err == nil {
 		return resp.StatusCode == http.StatusOK, nil
 	}
-
-	urlErr, ok := err.(*url.Error)
-	if !ok {
-		return false, err
-	}
-	httpErr, ok := urlErr.Err.(*HttpStatusError)
-	if !ok {
-		return false, err
-	}
-	if httpErr.Response.StatusCode == http.StatusNotFound {
+	if isNotFound(err) {
 		return false, nil
 	}
 
@@ -116,10 +117,18 @@ func (registry *Registry) initiateUpload(repository string) (*url.URL, error) {
 		return nil, err
 	}
 
-	location := resp.Header.Get("Location")
-	locationUrl, err := url.Parse(location)
+	// The Location header may be relative to the registry.
+	return registry.resolveLocation(resp, resp.Header.Get("Location"))
+}
+
+// fetchBlob downloads a small blob, such as a config or signature payload,
+// into memory and verifies it against its descriptor.
+func (registry *Registry) fetchBlob(repository string, descriptor distribution.Descriptor) ([]byte, error) {
+	blob, err := registry.DownloadBlob(repository, descriptor)
 	if err != nil {
 		return nil, err
 	}
-	return locationUrl, nil
+	defer blob.Close()
+
+	return readLimited(blob, registry.Limits.maxConfigSize(), "blob")
 }
@@ -0,0 +1,680 @@
+package registry
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/docker/distribution"
+	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// AnnotationZstdChunkedManifestChecksum holds the digest of the compressed
+	// table of contents of a zstd:chunked layer.
+	AnnotationZstdChunkedManifestChecksum = "io.github.containers.zstd-chunked.manifest-checksum"
+	// AnnotationZstdChunkedManifestPosition holds the position of the table of
+	// contents of a zstd:chunked layer as offset:length:uncompressedLength:type.
+	AnnotationZstdChunkedManifestPosition = "io.github.containers.zstd-chunked.manifest-position"
+
+	// estargzFooterSize is the size of the gzip member at the end of eStargz
+	// layers pointing at the TOC. Legacy stargz footers are smaller.
+	estargzFooterSize = 51
+	// estargzTOCName is the name of the tar entry holding the eStargz TOC.
+	estargzTOCName = "stargz.index.json"
+
+	// maxHardLinks bounds how many hard links are followed to reach a file.
+	maxHardLinks = 32
+)
+
+// LayerFormat is the way a layer is laid out, which decides whether its files
+// can be read individually.
+type LayerFormat string
+
+const (
+	// LayerFormatTar is an ordinary layer, which must be read in full.
+	LayerFormatTar         LayerFormat = "tar"
+	LayerFormatEStargz     LayerFormat = "estargz"
+	LayerFormatZstdChunked LayerFormat = "zstd:chunked"
+)
+
+// errRangeNotSupported is returned by fetchRange when the registry ignores
+// the Range header.
+var errRangeNotSupported = errors.New("registry does not support range requests")
+
+// TOCEntry is an entry of the table of contents of an eStargz or
+// zstd:chunked layer.
+type TOCEntry struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Size     int64  `json:"size,omitempty"`
+	LinkName string `json:"linkName,omitempty"`
+	Mode     int64  `json:"mode,omitempty"`
+	// Digest is the digest of the uncompressed content of a regular file.
+	Digest string `json:"digest,omitempty"`
+	// Offset is where the compressed content of the entry starts in the layer.
+	Offset int64 `json:"offset,omitempty"`
+	// EndOffset is where the compressed content ends. It is only set in
+	// zstd:chunked layers.
+	EndOffset   int64  `json:"endOffset,omitempty"`
+	ChunkOffset int64  `json:"chunkOffset,omitempty"`
+	ChunkSize   int64  `json:"chunkSize,omitempty"`
+	ChunkDigest string `json:"chunkDigest,omitempty"`
+}
+
+type tableOfContents struct {
+	Version int        `json:"version"`
+	Entries []TOCEntry `json:"entries"`
+}
+
+// LazyLayer reads individual files from a layer. For eStargz and zstd:chunked
+// layers only the table of contents and the chunks of the requested files are
+// downloaded, using HTTP Range requests. Any other layer is downloaded in
+// full whenever files are read from it.
+type LazyLayer struct {
+	registry   *Registry
+	repository string
+	descriptor distribution.Descriptor
+	format     LayerFormat
+
+	entries []TOCEntry
+	// tocOffset is where the TOC of an eStargz layer starts, which is where
+	// the compressed content of its last chunk ends.
+	tocOffset int64
+}
+
+// OpenLazyLayer prepares reading files from the layer described by
+// descriptor. Layers are only read lazily if the descriptor, which the
+// manifest digest covers, carries the digest of their table of contents: the
+// AnnotationEStargzTOCDigest annotation for eStargz layers, or the
+// AnnotationZstdChunkedManifestChecksum and position annotations for
+// zstd:chunked layers. The table of contents is verified against it, and every
+// file read through it against the digests it records. Any other layer, or
+// any layer if the registry does not support Range requests, is treated as an
+// ordinary one and verified against the layer digest as a whole.
+func (registry *Registry) OpenLazyLayer(repository string, descriptor distribution.Descriptor) (*LazyLayer, error) {
+	if err := descriptor.Digest.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid layer digest %v: %w", descriptor.Digest, err)
+	}
+	layer := &LazyLayer{
+		registry:   registry,
+		repository: repository,
+		descriptor: descriptor,
+		format:     LayerFormatTar,
+	}
+	if descriptor.Size <= 0 {
+		return layer, nil
+	}
+
+	var err error
+	compression, _ := MediaTypeCompression(descriptor.MediaType)
+	_, isEStargz := LayerEStargz(descriptor)
+	switch {
+	case compression == CompressionZstd && descriptor.Annotations[AnnotationZstdChunkedManifestPosition] != "" &&
+		descriptor.Annotations[AnnotationZstdChunkedManifestChecksum] != "":
+		err = layer.readZstdChunkedTOC()
+	case compression == CompressionGzip && isEStargz:
+		err = layer.readEStargzTOC()
+	}
+	if errors.Is(err, errRangeNotSupported) {
+		layer.format = LayerFormatTar
+		return layer, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return layer, nil
+}
+
+// Format returns how files are read from the layer.
+func (l *LazyLayer) Format() LayerFormat {
+	return l.format
+}
+
+// Entries returns the table of contents of an eStargz or zstd:chunked layer,
+// or nil for an ordinary layer.
+func (l *LazyLayer) Entries() []TOCEntry {
+	return l.entries
+}
+
+// ReadFile returns the content of the regular file with the given path, such
+// as "/etc/os-release". If the layer holds no such file, the error wraps
+// fs.ErrNotExist.
+func (l *LazyLayer) ReadFile(name string) ([]byte, error) {
+	files, err := l.ReadFiles(name)
+	if err != nil {
+		return nil, err
+	}
+	content, ok := files[cleanLayerPath(name)]
+	if !ok {
+		return nil, fmt.Errorf("%s in layer %s: %w", name, l.descriptor.Digest, fs.ErrNotExist)
+	}
+	return content, nil
+}
+
+// ReadFiles returns the contents of those of the given regular files which
+// the layer holds, keyed by their cleaned path without a leading slash.
+// Hard links within the layer are resolved; symbolic links are not followed.
+// Reading several files at once avoids downloading an ordinary layer more
+// than once.
+func (l *LazyLayer) ReadFiles(names ...string) (map[string][]byte, error) {
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[cleanLayerPath(name)] = true
+	}
+	if l.format == LayerFormatTar {
+		return l.readFilesFromTar(wanted)
+	}
+
+	files := make(map[string][]byte)
+	for name := range wanted {
+		content, found, err := l.readTOCFile(name)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			files[name] = content
+		}
+	}
+	return files, nil
+}
+
+func (l *LazyLayer) readFilesFromTar(wanted map[string]bool) (map[string][]byte, error) {
+	layer, _, err := l.registry.DownloadLayerTar(l.repository, l.descriptor)
+	if err != nil {
+		return nil, err
+	}
+	defer layer.Close()
+
+	// Hard links refer to files earlier in the layer, which are only known
+	// to be needed once the links have been read. The layer is spooled to a
+	// temporary file while it is read, so that it is downloaded only once.
+	spool, err := os.CreateTemp("", "layer-*.tar")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}()
+
+	files, links, err := l.scanTar(io.TeeReader(layer, spool), wanted)
+	if err != nil {
+		return nil, err
+	}
+	// Drain the rest of the blob so that its digest is verified.
+	if _, err := io.Copy(spool, layer); err != nil {
+		return nil, err
+	}
+
+	targets := make(map[string]string, len(wanted))
+	missing := make(map[string]bool)
+	for name := range wanted {
+		target, err := l.resolveHardLink(name, links)
+		if err != nil {
+			return nil, err
+		}
+		targets[name] = target
+		if _, ok := files[target]; !ok && target != name {
+			missing[target] = true
+		}
+	}
+	if len(missing) > 0 {
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		found, _, err := l.scanTar(spool, missing)
+		if err != nil {
+			return nil, err
+		}
+		for name, content := range found {
+			files[name] = content
+		}
+	}
+
+	resolved := make(map[string][]byte)
+	for name, target := range targets {
+		if content, ok := files[target]; ok {
+			resolved[name] = content
+		}
+	}
+	return resolved, nil
+}
+
+// scanTar reads a layer tar, returning the contents of the wanted regular
+// files and the targets of all hard links.
+func (l *LazyLayer) scanTar(layer io.Reader, wanted map[string]bool) (map[string][]byte, map[string]string, error) {
+	files := make(map[string][]byte)
+	links := make(map[string]string)
+	reader := tar.NewReader(layer)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return files, links, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		name := cleanLayerPath(header.Name)
+		delete(files, name)
+		delete(links, name)
+		switch header.Typeflag {
+		case tar.TypeReg:
+			if !wanted[name] {
+				continue
+			}
+			content, err := readLimited(reader, l.registry.Limits.maxFileSize(), "layer file")
+			if err != nil {
+				return nil, nil, err
+			}
+			files[name] = content
+		case tar.TypeLink:
+			links[name] = cleanLayerPath(header.Linkname)
+		}
+	}
+}
+
+// resolveHardLink follows the hard links starting at name, returning the name
+// of the entry they lead to and failing on cycles and overly long chains.
+func (l *LazyLayer) resolveHardLink(name string, links map[string]string) (string, error) {
+	visited := make(map[string]bool)
+	for {
+		target, ok := links[name]
+		if !ok {
+			return name, nil
+		}
+		if visited[name] || len(visited) >= maxHardLinks {
+			return "", fmt.Errorf("hard link cycle at %s in layer %s", name, l.descriptor.Digest)
+		}
+		visited[name] = true
+		name = target
+	}
+}
+
+// readTOCFile reads a regular file of an eStargz or zstd:chunked layer
+// chunk by chunk, following hard links.
+func (l *LazyLayer) readTOCFile(name string) ([]byte, bool, error) {
+	visited := make(map[string]bool)
+	chunks, link := l.tocChunks(name)
+	for link != "" {
+		if visited[name] || len(visited) >= maxHardLinks {
+			return nil, false, fmt.Errorf("hard link cycle at %s in layer %s", name, l.descriptor.Digest)
+		}
+		visited[name] = true
+		name = link
+		chunks, link = l.tocChunks(name)
+	}
+	if len(chunks) == 0 {
+		return nil, false, nil
+	}
+
+	file := chunks[0]
+	if file.Size < 0 {
+		return nil, false, fmt.Errorf("file %s in layer %s has invalid size %d", name, l.descriptor.Digest, file.Size)
+	}
+	if limit := l.registry.Limits.maxFileSize(); file.Size > limit {
+		return nil, false, &LimitExceededError{Kind: "layer file", Limit: limit}
+	}
+	if file.Size > 0 && file.Digest == "" {
+		for _, chunk := range chunks {
+			if chunk.ChunkDigest == "" {
+				return nil, false, fmt.Errorf("file %s in layer %s has no digest in the TOC", name, l.descriptor.Digest)
+			}
+		}
+	}
+
+	content := make([]byte, 0, file.Size)
+	for _, chunk := range chunks {
+		if chunk.ChunkOffset < 0 || chunk.ChunkSize < 0 || chunk.ChunkOffset != int64(len(content)) {
+			return nil, false, fmt.Errorf("file %s in layer %s has an invalid chunk at %d", name, l.descriptor.Digest, chunk.ChunkOffset)
+		}
+		size := chunk.ChunkSize
+		if size == 0 {
+			size = file.Size - chunk.ChunkOffset
+		}
+		if size == 0 {
+			continue
+		}
+		if size < 0 || int64(len(content))+size > file.Size {
+			return nil, false, fmt.Errorf("chunks of file %s in layer %s exceed its size %d", name, l.descriptor.Digest, file.Size)
+		}
+		data, err := l.readChunk(chunk, size)
+		if err != nil {
+			return nil, false, err
+		}
+		content = append(content, data...)
+	}
+
+	if int64(len(content)) != file.Size {
+		return nil, false, fmt.Errorf("file %s in layer %s has size %d, expected %d", name, l.descriptor.Digest, len(content), file.Size)
+	}
+	if file.Digest != "" {
+		if err := verifyContent(content, file.Digest); err != nil {
+			return nil, false, fmt.Errorf("file %s in layer %s: %w", name, l.descriptor.Digest, err)
+		}
+	}
+	return content, true, nil
+}
+
+// tocChunks returns the TOC entries holding the content of the regular file
+// with the given name, or the target if the last entry for it is a hard link.
+func (l *LazyLayer) tocChunks(name string) ([]TOCEntry, string) {
+	var chunks []TOCEntry
+	link := ""
+	for _, entry := range l.entries {
+		if cleanLayerPath(entry.Name) != name {
+			continue
+		}
+		switch entry.Type {
+		case "reg":
+			chunks, link = []TOCEntry{entry}, ""
+		case "chunk":
+			if len(chunks) > 0 {
+				chunks = append(chunks, entry)
+			}
+		case "hardlink":
+			chunks, link = nil, cleanLayerPath(entry.LinkName)
+		default:
+			chunks, link = nil, ""
+		}
+	}
+	return chunks, link
+}
+
+// readChunk fetches the compressed range holding a chunk and decompresses
+// size bytes from it.
+func (l *LazyLayer) readChunk(chunk TOCEntry, size int64) ([]byte, error) {
+	end := chunk.EndOffset
+	if l.format == LayerFormatEStargz {
+		end = l.chunkEnd(chunk.Offset)
+	}
+	if chunk.Offset <= 0 || end <= chunk.Offset || end > l.descriptor.Size {
+		return nil, fmt.Errorf("invalid offsets for %s in layer %s", chunk.Name, l.descriptor.Digest)
+	}
+	if limit := l.registry.Limits.maxFileSize(); end-chunk.Offset > limit {
+		return nil, &LimitExceededError{Kind: "compressed chunk", Limit: limit}
+	}
+
+	compressed, err := l.registry.fetchRange(l.repository, l.descriptor.Digest, chunk.Offset, end-chunk.Offset)
+	if err != nil {
+		return nil, err
+	}
+	decompressed, err := l.decompressor(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer decompressed.Close()
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(decompressed, data); err != nil {
+		return nil, fmt.Errorf("reading %s from layer %s: %w", chunk.Name, l.descriptor.Digest, err)
+	}
+	if chunk.ChunkDigest != "" {
+		if err := verifyContent(data, chunk.ChunkDigest); err != nil {
+			return nil, fmt.Errorf("chunk of %s in layer %s: %w", chunk.Name, l.descriptor.Digest, err)
+		}
+	}
+	return data, nil
+}
+
+// chunkEnd returns where the compressed eStargz chunk starting at offset ends,
+// which is where the next chunk or the TOC starts.
+func (l *LazyLayer) chunkEnd(offset int64) int64 {
+	end := l.tocOffset
+	for _, entry := range l.entries {
+		if entry.Offset > offset && entry.Offset < end {
+			end = entry.Offset
+		}
+	}
+	return end
+}
+
+func (l *LazyLayer) decompressor(r io.Reader) (io.ReadCloser, error) {
+	if l.format == LayerFormatZstdChunked {
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return gzip.NewReader(r)
+}
+
+// readEStargzTOC reads the footer of an eStargz layer and its table of
+// contents.
+func (l *LazyLayer) readEStargzTOC() error {
+	if l.descriptor.Size <= estargzFooterSize {
+		return fmt.Errorf("layer %s is annotated as eStargz but is too small", l.descriptor.Digest)
+	}
+	footer, err := l.registry.fetchRange(l.repository, l.descriptor.Digest, l.descriptor.Size-estargzFooterSize, estargzFooterSize)
+	if err != nil {
+		return err
+	}
+	tocOffset, footerSize, ok := parseEStargzFooter(footer)
+	if !ok {
+		return fmt.Errorf("layer %s is annotated as eStargz but has no eStargz footer", l.descriptor.Digest)
+	}
+	if tocOffset <= 0 || tocOffset >= l.descriptor.Size-footerSize {
+		return fmt.Errorf("invalid eStargz TOC offset %d in layer %s", tocOffset, l.descriptor.Digest)
+	}
+
+	tocLength := l.descriptor.Size - footerSize - tocOffset
+	if limit := l.registry.Limits.maxConfigSize(); tocLength > limit {
+		return &LimitExceededError{Kind: "eStargz TOC", Limit: limit}
+	}
+	compressed, err := l.registry.fetchRange(l.repository, l.descriptor.Digest, tocOffset, tocLength)
+	if err != nil {
+		return err
+	}
+	gzipReader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return fmt.Errorf("invalid eStargz TOC in layer %s: %w", l.descriptor.Digest, err)
+	}
+	reader := tar.NewReader(gzipReader)
+	header, err := reader.Next()
+	if err != nil {
+		return fmt.Errorf("invalid eStargz TOC in layer %s: %w", l.descriptor.Digest, err)
+	}
+	if header.Name != estargzTOCName {
+		return fmt.Errorf("invalid eStargz TOC in layer %s: unexpected entry %q", l.descriptor.Digest, header.Name)
+	}
+	toc, err := readLimited(reader, l.registry.Limits.maxConfigSize(), "eStargz TOC")
+	if err != nil {
+		return err
+	}
+	estargz, _ := LayerEStargz(l.descriptor)
+	if actual := estargz.TOCDigest.Algorithm().FromBytes(toc); actual != estargz.TOCDigest {
+		return fmt.Errorf("%w: eStargz TOC of layer %s has digest %s, expected %s", ErrDigestMismatch, l.descriptor.Digest, actual, estargz.TOCDigest)
+	}
+
+	if err := l.decodeTOC(toc); err != nil {
+		return err
+	}
+	l.format = LayerFormatEStargz
+	l.tocOffset = tocOffset
+	return nil
+}
+
+// parseEStargzFooter returns the TOC offset recorded in the gzip extra field
+// of the eStargz or legacy stargz footer at the end of the given bytes. The
+// footer is 51 or 47 bytes long depending on the format, but its exact size
+// depends on the gzip implementation which wrote it, so every gzip member
+// header in the bytes is tried.
+func parseEStargzFooter(tail []byte) (tocOffset, footerSize int64, ok bool) {
+	for start := 0; start+len(gzipMagic) <= len(tail); start++ {
+		if !bytes.HasPrefix(tail[start:], gzipMagic) {
+			continue
+		}
+		extra, err := gzipExtra(tail[start:])
+		if err != nil {
+			continue
+		}
+		// eStargz wraps the offset in a subfield with the ID "SG".
+		if len(extra) == 26 && extra[0] == 'S' && extra[1] == 'G' && binary.LittleEndian.Uint16(extra[2:4]) == 22 {
+			extra = extra[4:]
+		}
+		if offset, ok := parseStargzOffset(extra); ok {
+			return offset, int64(len(tail) - start), true
+		}
+	}
+	return 0, 0, false
+}
+
+func gzipExtra(member []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(member))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return reader.Header.Extra, nil
+}
+
+func parseStargzOffset(extra []byte) (int64, bool) {
+	if len(extra) != 22 || string(extra[16:]) != "STARGZ" {
+		return 0, false
+	}
+	offset, err := strconv.ParseInt(string(extra[:16]), 16, 64)
+	return offset, err == nil
+}
+
+// readZstdChunkedTOC reads the table of contents of a zstd:chunked layer
+// from the position given by its annotations.
+func (l *LazyLayer) readZstdChunkedTOC() error {
+	position := strings.Split(l.descriptor.Annotations[AnnotationZstdChunkedManifestPosition], ":")
+	if len(position) != 4 {
+		return fmt.Errorf("invalid zstd:chunked manifest position in layer %s", l.descriptor.Digest)
+	}
+	offset, offsetErr := strconv.ParseInt(position[0], 10, 64)
+	length, lengthErr := strconv.ParseInt(position[1], 10, 64)
+	uncompressedLength, uncompressedErr := strconv.ParseInt(position[2], 10, 64)
+	if offsetErr != nil || lengthErr != nil || uncompressedErr != nil ||
+		offset <= 0 || length <= 0 || offset+length > l.descriptor.Size {
+		return fmt.Errorf("invalid zstd:chunked manifest position in layer %s", l.descriptor.Digest)
+	}
+	limit := l.registry.Limits.maxConfigSize()
+	if length > limit || uncompressedLength > limit {
+		return &LimitExceededError{Kind: "zstd:chunked manifest", Limit: limit}
+	}
+
+	compressed, err := l.registry.fetchRange(l.repository, l.descriptor.Digest, offset, length)
+	if err != nil {
+		return err
+	}
+	if err := verifyContent(compressed, l.descriptor.Annotations[AnnotationZstdChunkedManifestChecksum]); err != nil {
+		return fmt.Errorf("zstd:chunked manifest of layer %s: %w", l.descriptor.Digest, err)
+	}
+
+	decoder, err := zstd.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return err
+	}
+	defer decoder.Close()
+	toc, err := readLimited(decoder, limit, "zstd:chunked manifest")
+	if err != nil {
+		return fmt.Errorf("invalid zstd:chunked manifest in layer %s: %w", l.descriptor.Digest, err)
+	}
+
+	if err := l.decodeTOC(toc); err != nil {
+		return err
+	}
+	l.format = LayerFormatZstdChunked
+	return nil
+}
+
+func (l *LazyLayer) decodeTOC(payload []byte) error {
+	var toc tableOfContents
+	if err := json.Unmarshal(payload, &toc); err != nil {
+		return fmt.Errorf("invalid TOC in layer %s: %w", l.descriptor.Digest, err)
+	}
+	l.entries = toc.Entries
+	return nil
+}
+
+// fetchRange fetches length bytes of a blob starting at offset.
+func (registry *Registry) fetchRange(repository string, d digest.Digest, offset, length int64) ([]byte, error) {
+	url := registry.url("/v2/%s/blobs/%s", repository, d)
+	registry.Logf("registry.layer.download-range url=%s repository=%s digest=%s offset=%d length=%d", url, repository, d, offset, length)
+
+	resp, err := registry.getBlob(context.Background(), repository, d, fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, errRangeNotSupported
+	}
+	if start, _, ok := parseContentRange(resp.Header.Get("Content-Range")); !ok || start != offset {
+		return nil, fmt.Errorf("range of blob %s at %d: unexpected Content-Range %q", d, offset, resp.Header.Get("Content-Range"))
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, length))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) != length {
+		return nil, fmt.Errorf("range of blob %s at %d has %d bytes, expected %d", d, offset, len(content), length)
+	}
+	return content, nil
+}
+
+// parseContentRange parses a Content-Range header such as
+// "bytes 100-199/200", returning a total of -1 if it is given as "*".
+func parseContentRange(contentRange string) (start, total int64, ok bool) {
+	if !strings.HasPrefix(contentRange, "bytes ") {
+		return 0, 0, false
+	}
+	spec := strings.TrimPrefix(contentRange, "bytes ")
+	byteRange, size, found := strings.Cut(spec, "/")
+	if !found {
+		return 0, 0, false
+	}
+	first, last, found := strings.Cut(byteRange, "-")
+	if !found {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	if end, err := strconv.ParseInt(last, 10, 64); err != nil || end < start {
+		return 0, 0, false
+	}
+	total = -1
+	if size != "*" {
+		if total, err = strconv.ParseInt(size, 10, 64); err != nil {
+			return 0, 0, false
+		}
+	}
+	return start, total, true
+}
+
+// verifyContent checks content against a digest given as a string.
+func verifyContent(content []byte, expected string) error {
+	d, err := digest.Parse(expected)
+	if err != nil {
+		return fmt.Errorf("invalid digest %q: %w", expected, err)
+	}
+	if actual := d.Algorithm().FromBytes(content); actual != d {
+		return fmt.Errorf("%w: content has digest %s, expected %s", ErrDigestMismatch, actual, d)
+	}
+	return nil
+}
+
+// cleanLayerPath normalizes a path within a layer, so that "/etc/passwd",
+// "./etc/passwd" and "etc/passwd" are the same.
+func cleanLayerPath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
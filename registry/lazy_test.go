@@ -0,0 +1,431 @@
+package registry
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
+)
+
+var lazyTestFiles = []struct {
+	name    string
+	content string
+}{
+	{"etc/os-release", "ID=alpine\n"},
+	{"usr/bin/large", string(bytes.Repeat([]byte("0123456789"), 1000))},
+	{"var/lib/apk/db/installed", "P:musl\n"},
+}
+
+// switchingWriter counts the bytes written through it to whichever writer
+// is current.
+type switchingWriter struct {
+	buffer  bytes.Buffer
+	current *gzip.Writer
+}
+
+func (w *switchingWriter) Write(p []byte) (int, error) {
+	return w.current.Write(p)
+}
+
+func (w *switchingWriter) newMember(t *testing.T) int64 {
+	if err := w.current.Close(); err != nil {
+		t.Fatal(err)
+	}
+	w.current = gzip.NewWriter(&w.buffer)
+	return int64(w.buffer.Len())
+}
+
+// buildEStargz builds an eStargz layer in which each file starts a new gzip
+// member, followed by the TOC and the footer.
+func buildEStargz(t *testing.T) ([]byte, digest.Digest) {
+	w := &switchingWriter{}
+	w.current = gzip.NewWriter(&w.buffer)
+	tw := tar.NewWriter(w)
+
+	var toc tableOfContents
+	toc.Version = 1
+	for _, file := range lazyTestFiles {
+		if err := tw.WriteHeader(&tar.Header{Name: file.name, Typeflag: tar.TypeReg, Size: int64(len(file.content)), Mode: 0644}); err != nil {
+			t.Fatal(err)
+		}
+		// Store large files in two chunks.
+		chunkSize := int64(len(file.content))
+		if chunkSize > 5000 {
+			chunkSize = 5000
+		}
+		for chunkOffset := int64(0); chunkOffset < int64(len(file.content)); chunkOffset += chunkSize {
+			chunk := file.content[chunkOffset : chunkOffset+chunkSize]
+			entry := TOCEntry{
+				Name:        file.name,
+				Type:        "chunk",
+				Offset:      w.newMember(t),
+				ChunkOffset: chunkOffset,
+				ChunkSize:   chunkSize,
+				ChunkDigest: digest.FromString(chunk).String(),
+			}
+			if chunkOffset == 0 {
+				entry.Type = "reg"
+				entry.Size = int64(len(file.content))
+				entry.Digest = digest.FromString(file.content).String()
+			}
+			toc.Entries = append(toc.Entries, entry)
+			if _, err := tw.Write([]byte(chunk)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	toc.Entries = append(toc.Entries, TOCEntry{Name: "etc/hostname", Type: "hardlink", LinkName: "etc/os-release"})
+
+	tocJSON, err := json.Marshal(toc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Pad the last file so that the TOC member starts with its tar header.
+	if err := tw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	tocOffset := w.newMember(t)
+	if err := tw.WriteHeader(&tar.Header{Name: estargzTOCName, Typeflag: tar.TypeReg, Size: int64(len(tocJSON))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(tocJSON); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.current.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	footer, err := gzip.NewWriterLevel(&w.buffer, gzip.NoCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	footer.Header.Extra = append([]byte{'S', 'G', 22, 0}, fmt.Sprintf("%016xSTARGZ", tocOffset)...)
+	if err := footer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return w.buffer.Bytes(), digest.FromBytes(tocJSON)
+}
+
+// buildZstdChunked builds a zstd:chunked layer in which each file is a
+// separate zstd frame, followed by the compressed TOC.
+func buildZstdChunked(t *testing.T) ([]byte, map[string]string) {
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A leading frame stands in for the tar headers zstd:chunked layers start with.
+	layer := encoder.EncodeAll([]byte("header"), nil)
+	var toc tableOfContents
+	toc.Version = 1
+	for _, file := range lazyTestFiles {
+		offset := int64(len(layer))
+		layer = encoder.EncodeAll([]byte(file.content), layer)
+		toc.Entries = append(toc.Entries, TOCEntry{
+			Name:      file.name,
+			Type:      "reg",
+			Size:      int64(len(file.content)),
+			Digest:    digest.FromString(file.content).String(),
+			Offset:    offset,
+			EndOffset: int64(len(layer)),
+		})
+	}
+
+	tocJSON, err := json.Marshal(toc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed := encoder.EncodeAll(tocJSON, nil)
+	offset := len(layer)
+	layer = append(layer, compressed...)
+	return layer, map[string]string{
+		AnnotationZstdChunkedManifestChecksum: digest.FromBytes(compressed).String(),
+		AnnotationZstdChunkedManifestPosition: fmt.Sprintf("%d:%d:%d:1", offset, len(compressed), len(tocJSON)),
+	}
+}
+
+func TestLazyLayer(t *testing.T) {
+	estargz, tocDigest := buildEStargz(t)
+	zstdChunked, zstdAnnotations := buildZstdChunked(t)
+
+	for _, test := range []struct {
+		format      LayerFormat
+		mediaType   string
+		blob        []byte
+		annotations map[string]string
+	}{
+		{LayerFormatEStargz, MediaTypeImageLayerGzip, estargz, map[string]string{AnnotationEStargzTOCDigest: tocDigest.String()}},
+		{LayerFormatZstdChunked, MediaTypeImageLayerZstd, zstdChunked, zstdAnnotations},
+	} {
+		fake, r := newFakeRegistry(t)
+		d := digest.FromBytes(test.blob)
+		fake.blobs[d] = test.blob
+		descriptor := distribution.Descriptor{MediaType: test.mediaType, Size: int64(len(test.blob)), Digest: d, Annotations: test.annotations}
+
+		layer, err := r.OpenLazyLayer("test/image", descriptor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if layer.Format() != test.format {
+			t.Errorf("Expected format %s but got: %s", test.format, layer.Format())
+		}
+
+		files, err := layer.ReadFiles("/etc/os-release", "./usr/bin/large", "etc/missing")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != 2 || string(files["etc/os-release"]) != lazyTestFiles[0].content || string(files["usr/bin/large"]) != lazyTestFiles[1].content {
+			t.Errorf("Unexpected files read from %s layer: %v", test.format, files)
+		}
+		if _, err := layer.ReadFile("etc/missing"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Expected fs.ErrNotExist but got: %v", err)
+		}
+		if fake.fullBlobReads != 0 {
+			t.Errorf("Expected only range requests for a %s layer but got %d full reads", test.format, fake.fullBlobReads)
+		}
+	}
+}
+
+func TestLazyLayerEStargzHardLink(t *testing.T) {
+	estargz, tocDigest := buildEStargz(t)
+	fake, r := newFakeRegistry(t)
+	d := digest.FromBytes(estargz)
+	fake.blobs[d] = estargz
+
+	layer, err := r.OpenLazyLayer("test/image", distribution.Descriptor{
+		MediaType:   MediaTypeImageLayerGzip,
+		Size:        int64(len(estargz)),
+		Digest:      d,
+		Annotations: map[string]string{AnnotationEStargzTOCDigest: tocDigest.String()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := layer.ReadFile("etc/hostname")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != lazyTestFiles[0].content {
+		t.Errorf("Unexpected content of hard link: %q", content)
+	}
+
+	_, err = r.OpenLazyLayer("test/image", distribution.Descriptor{
+		MediaType:   MediaTypeImageLayerGzip,
+		Size:        int64(len(estargz)),
+		Digest:      d,
+		Annotations: map[string]string{AnnotationEStargzTOCDigest: fakeDigest.String()},
+	})
+	if !errors.Is(err, ErrDigestMismatch) {
+		t.Errorf("Expected ErrDigestMismatch for a wrong TOC digest but got: %v", err)
+	}
+}
+
+func TestLazyLayerFallback(t *testing.T) {
+	var layer bytes.Buffer
+	gzipWriter := gzip.NewWriter(&layer)
+	tw := tar.NewWriter(gzipWriter)
+	for _, file := range lazyTestFiles {
+		if err := tw.WriteHeader(&tar.Header{Name: file.name, Typeflag: tar.TypeReg, Size: int64(len(file.content)), Mode: 0644}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(file.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: "etc/hostname", Typeflag: tar.TypeLink, Linkname: "etc/os-release"}); err != nil {
+		t.Fatal(err)
+	}
+	_ = tw.Close()
+	_ = gzipWriter.Close()
+
+	fake, r := newFakeRegistry(t)
+	d := digest.FromBytes(layer.Bytes())
+	fake.blobs[d] = layer.Bytes()
+
+	lazy, err := r.OpenLazyLayer("test/image", distribution.Descriptor{MediaType: MediaTypeImageLayerGzip, Size: int64(layer.Len()), Digest: d})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lazy.Format() != LayerFormatTar {
+		t.Errorf("Expected an ordinary layer but got: %s", lazy.Format())
+	}
+	files, err := lazy.ReadFiles("/var/lib/apk/db/installed", "/etc/hostname")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(files["var/lib/apk/db/installed"]) != lazyTestFiles[2].content || string(files["etc/hostname"]) != lazyTestFiles[0].content {
+		t.Errorf("Unexpected files read from an ordinary layer: %v", files)
+	}
+}
+
+func TestLazyLayerRequiresAnnotatedTOC(t *testing.T) {
+	estargz, _ := buildEStargz(t)
+	fake, r := newFakeRegistry(t)
+	d := digest.FromBytes(estargz)
+	fake.blobs[d] = estargz
+
+	layer, err := r.OpenLazyLayer("test/image", distribution.Descriptor{MediaType: MediaTypeImageLayerGzip, Size: int64(len(estargz)), Digest: d})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layer.Format() != LayerFormatTar {
+		t.Errorf("Expected an eStargz layer without a TOC digest to be read as an ordinary layer but got: %s", layer.Format())
+	}
+	content, err := layer.ReadFile(lazyTestFiles[0].name)
+	if err != nil || string(content) != lazyTestFiles[0].content {
+		t.Errorf("Expected %q but got: %q, %v", lazyTestFiles[0].content, content, err)
+	}
+}
+
+func TestLazyLayerInvalidTOC(t *testing.T) {
+	_, r := newFakeRegistry(t)
+	for name, entries := range map[string][]TOCEntry{
+		"self link": {{Name: "a", Type: "hardlink", LinkName: "a"}},
+		"link cycle": {
+			{Name: "a", Type: "hardlink", LinkName: "b"},
+			{Name: "b", Type: "hardlink", LinkName: "a"},
+		},
+		"negative size":         {{Name: "a", Type: "reg", Size: -1, Offset: 10, Digest: fakeDigest.String()}},
+		"negative chunk offset": {{Name: "a", Type: "reg", Size: 10, Offset: 10, ChunkOffset: -5, Digest: fakeDigest.String()}},
+		"negative chunk size":   {{Name: "a", Type: "reg", Size: 10, Offset: 10, ChunkSize: -5, Digest: fakeDigest.String()}},
+		"no digest":             {{Name: "a", Type: "reg", Size: 10, Offset: 10}},
+		"too large":             {{Name: "a", Type: "reg", Size: 1 << 40, Offset: 10, Digest: fakeDigest.String()}},
+		"chunk beyond layer":    {{Name: "a", Type: "reg", Size: 10, Offset: 10, EndOffset: 1 << 31, Digest: fakeDigest.String()}},
+		"chunk too large":       {{Name: "a", Type: "reg", Size: 10, Offset: 10, EndOffset: 10 + DefaultMaxFileSize + 1, Digest: fakeDigest.String()}},
+	} {
+		descriptor := distribution.Descriptor{Digest: fakeDigest, Size: 1 << 30}
+		layer := &LazyLayer{registry: r, repository: "test/image", descriptor: descriptor, format: LayerFormatZstdChunked, entries: entries}
+		if _, err := layer.ReadFile("a"); err == nil || errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Expected an error for a TOC with %s but got: %v", name, err)
+		}
+	}
+}
+
+func TestLazyLayerTarHardLinkCycle(t *testing.T) {
+	var layer bytes.Buffer
+	gzipWriter := gzip.NewWriter(&layer)
+	tw := tar.NewWriter(gzipWriter)
+	for _, link := range [][2]string{{"a", "b"}, {"b", "a"}} {
+		if err := tw.WriteHeader(&tar.Header{Name: link[0], Typeflag: tar.TypeLink, Linkname: link[1]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = tw.Close()
+	_ = gzipWriter.Close()
+
+	fake, r := newFakeRegistry(t)
+	d := digest.FromBytes(layer.Bytes())
+	fake.blobs[d] = layer.Bytes()
+
+	lazy, err := r.OpenLazyLayer("test/image", distribution.Descriptor{MediaType: MediaTypeImageLayerGzip, Size: int64(layer.Len()), Digest: d})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lazy.ReadFile("a"); err == nil || errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected an error for a hard link cycle but got: %v", err)
+	}
+	if fake.fullBlobReads != 1 {
+		t.Errorf("Expected the layer to be downloaded once but got %d downloads", fake.fullBlobReads)
+	}
+}
+
+func TestLazyLayerTarHardLinkChain(t *testing.T) {
+	var layer bytes.Buffer
+	gzipWriter := gzip.NewWriter(&layer)
+	tw := tar.NewWriter(gzipWriter)
+	content := []byte("content")
+	if err := tw.WriteHeader(&tar.Header{Name: "c", Typeflag: tar.TypeReg, Size: int64(len(content)), Mode: 0o644}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	for _, link := range [][2]string{{"b", "c"}, {"a", "b"}} {
+		if err := tw.WriteHeader(&tar.Header{Name: link[0], Typeflag: tar.TypeLink, Linkname: link[1]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = tw.Close()
+	_ = gzipWriter.Close()
+
+	fake, r := newFakeRegistry(t)
+	d := digest.FromBytes(layer.Bytes())
+	fake.blobs[d] = layer.Bytes()
+
+	lazy, err := r.OpenLazyLayer("test/image", distribution.Descriptor{MediaType: MediaTypeImageLayerGzip, Size: int64(layer.Len()), Digest: d})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := lazy.ReadFiles("a", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(files["a"]) != string(content) || string(files["b"]) != string(content) {
+		t.Errorf("Expected both links to resolve to %q but got: %q", content, files)
+	}
+	if fake.fullBlobReads != 1 {
+		t.Errorf("Expected the layer to be downloaded once but got %d downloads", fake.fullBlobReads)
+	}
+}
+
+func TestFetchRangeChecksContentRange(t *testing.T) {
+	content := []byte("0123456789")
+	ignoreRange := false
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ignoreRange {
+			_, _ = w.Write(content)
+			return
+		}
+		// Answers every range request with the start of the blob.
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-4/%d", len(content)))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write(content[:5])
+	}))
+	t.Cleanup(s.Close)
+
+	r, err := New(s.URL, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Logf = Quiet
+
+	if _, err := r.fetchRange("test/image", digest.FromBytes(content), 5, 5); err == nil {
+		t.Error("Expected an error for a range starting at the wrong offset")
+	}
+	ignoreRange = true
+	if _, err := r.fetchRange("test/image", digest.FromBytes(content), 5, 5); !errors.Is(err, errRangeNotSupported) {
+		t.Errorf("Expected errRangeNotSupported for a server ignoring the range but got: %v", err)
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	for header, expected := range map[string]struct {
+		start, total int64
+		ok           bool
+	}{
+		"bytes 100-199/200": {100, 200, true},
+		"bytes 0-0/*":       {0, -1, true},
+		"bytes */200":       {0, 0, false},
+		"bytes 200-100/300": {0, 0, false},
+		"items 0-1/2":       {0, 0, false},
+		"":                  {0, 0, false},
+	} {
+		start, total, ok := parseContentRange(header)
+		if ok != expected.ok || (ok && (start != expected.start || total != expected.total)) {
+			t.Errorf("Expected parseContentRange(%q) to return %d, %d, %v but got %d, %d, %v", header, expected.start, expected.total, expected.ok, start, total, ok)
+		}
+	}
+}
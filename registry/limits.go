@@ -0,0 +1,270 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/opencontainers/go-digest"
+)
+
+// Default limits applied when the corresponding field of Limits, or of
+// TokenTransport and ErrorTransport, is zero.
+const (
+	// DefaultMaxManifestSize matches the limit of the reference registry implementation.
+	DefaultMaxManifestSize int64 = 4 << 20
+	DefaultMaxConfigSize   int64 = 32 << 20
+	DefaultMaxResponseSize int64 = 4 << 20
+	DefaultMaxFileSize     int64 = 64 << 20
+	DefaultMaxTokenSize    int64 = 1 << 20
+	// DefaultMaxErrorBodySize bounds the part of an error response kept in HttpStatusError.Body.
+	DefaultMaxErrorBodySize int64 = 64 << 10
+	DefaultMaxIndexDepth          = 4
+	DefaultMaxIndexEntries        = 1024
+	DefaultMaxListEntries         = 1 << 20
+	DefaultMaxPages               = 10000
+)
+
+// Limits bounds what the registry client accepts from a registry, to protect
+// against hostile or broken registries. Zero fields use the defaults above.
+type Limits struct {
+	// MaxManifestSize bounds manifests, indexes and referrers responses.
+	MaxManifestSize int64
+	// MaxConfigSize bounds config blobs and the other small blobs, such as
+	// signature payloads and attestations, which are read into memory.
+	MaxConfigSize int64
+	// MaxResponseSize bounds each page of the catalog and tag lists.
+	MaxResponseSize int64
+	// MaxFileSize bounds the files LazyLayer reads from layers into memory.
+	MaxFileSize int64
+	// MaxIndexDepth bounds how deeply ImageManifests follows nested indexes.
+	MaxIndexDepth int
+	// MaxIndexEntries bounds the number of manifests an index may list. For
+	// ImageManifests it bounds the total across all nested indexes, and for
+	// Referrers the total across all pages.
+	MaxIndexEntries int
+	// MaxListEntries bounds the total number of tags or repositories listed
+	// across all pages of the tag list and the catalog.
+	MaxListEntries int
+	// MaxPages bounds the number of pages followed when listing tags,
+	// repositories or referrers.
+	MaxPages int
+	// MaxTokenSize bounds token responses, and MaxErrorBodySize the part of an
+	// error response kept in HttpStatusError.Body. They apply to the
+	// TokenTransport and ErrorTransport of the registry, as created by New or
+	// WrapTransport, unless their own MaxTokenSize and MaxBodySize are set.
+	MaxTokenSize     int64
+	MaxErrorBodySize int64
+}
+
+// LimitExceededError is returned when a response exceeds one of the Limits.
+type LimitExceededError struct {
+	// Kind names what exceeded its limit, such as "manifest" or "index depth".
+	Kind  string
+	Limit int64
+}
+
+func (err *LimitExceededError) Error() string {
+	return fmt.Sprintf("%s exceeds limit of %d", err.Kind, err.Limit)
+}
+
+func (limits Limits) maxManifestSize() int64 {
+	return limitOrDefault(limits.MaxManifestSize, DefaultMaxManifestSize)
+}
+
+func (limits Limits) maxConfigSize() int64 {
+	return limitOrDefault(limits.MaxConfigSize, DefaultMaxConfigSize)
+}
+
+func (limits Limits) maxResponseSize() int64 {
+	return limitOrDefault(limits.MaxResponseSize, DefaultMaxResponseSize)
+}
+
+func (limits Limits) maxFileSize() int64 {
+	return limitOrDefault(limits.MaxFileSize, DefaultMaxFileSize)
+}
+
+func (limits *Limits) maxTokenSize() int64 {
+	if limits == nil {
+		return DefaultMaxTokenSize
+	}
+	return limitOrDefault(limits.MaxTokenSize, DefaultMaxTokenSize)
+}
+
+func (limits *Limits) maxErrorBodySize() int64 {
+	if limits == nil {
+		return DefaultMaxErrorBodySize
+	}
+	return limitOrDefault(limits.MaxErrorBodySize, DefaultMaxErrorBodySize)
+}
+
+func (limits Limits) maxIndexDepth() int {
+	return int(limitOrDefault(int64(limits.MaxIndexDepth), DefaultMaxIndexDepth))
+}
+
+func (limits Limits) maxIndexEntries() int {
+	return int(limitOrDefault(int64(limits.MaxIndexEntries), DefaultMaxIndexEntries))
+}
+
+func (limits Limits) maxListEntries() int {
+	return int(limitOrDefault(int64(limits.MaxListEntries), DefaultMaxListEntries))
+}
+
+func (limits Limits) maxPages() int {
+	return int(limitOrDefault(int64(limits.MaxPages), DefaultMaxPages))
+}
+
+func limitOrDefault(limit, defaultLimit int64) int64 {
+	if limit > 0 {
+		return limit
+	}
+	return defaultLimit
+}
+
+// useLimits makes the ErrorTransport and TokenTransport in the given
+// transport stack fall back to limits for the limits they do not set.
+func useLimits(transport http.RoundTripper, limits *Limits) {
+	for transport != nil {
+		switch t := transport.(type) {
+		case *ErrorTransport:
+			t.limits = limits
+			transport = t.Transport
+		case *BasicTransport:
+			transport = t.Transport
+		case *TokenTransport:
+			t.limits = limits
+			transport = t.Transport
+		default:
+			return
+		}
+	}
+}
+
+// readLimited reads r to the end, failing with a *LimitExceededError as soon
+// as more than limit bytes have been read.
+func readLimited(r io.Reader, limit int64, kind string) ([]byte, error) {
+	content, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > limit {
+		return nil, &LimitExceededError{Kind: kind, Limit: limit}
+	}
+	return content, nil
+}
+
+func (registry *Registry) readManifest(r io.Reader) ([]byte, error) {
+	return readLimited(r, registry.Limits.maxManifestSize(), "manifest")
+}
+
+// fetchIndex fetches and decodes the index with the given reference,
+// enforcing the MaxIndexEntries limit.
+func (registry *Registry) fetchIndex(repository, reference string) (Index, error) {
+	payload, _, err := registry.fetchManifest(repository, reference, MediaTypeImageIndex, manifestlist.MediaTypeManifestList)
+	if err != nil {
+		return Index{}, err
+	}
+	return registry.decodeIndex(reference, payload)
+}
+
+func (registry *Registry) decodeIndex(reference string, payload []byte) (Index, error) {
+	var index Index
+	if err := json.Unmarshal(payload, &index); err != nil {
+		return Index{}, fmt.Errorf("invalid index %s: %w", reference, err)
+	}
+	if err := registry.checkIndexEntries(len(index.Manifests)); err != nil {
+		return Index{}, err
+	}
+	return index, nil
+}
+
+// checkIndexEntries enforces the MaxIndexEntries limit on a single index.
+func (registry *Registry) checkIndexEntries(entries int) error {
+	if limit := registry.Limits.maxIndexEntries(); entries > limit {
+		return &LimitExceededError{Kind: "index entries", Limit: int64(limit)}
+	}
+	return nil
+}
+
+// checkListEntries enforces the MaxListEntries limit on the total number of
+// entries listed across the pages of a listing.
+func (registry *Registry) checkListEntries(entries int) error {
+	if limit := registry.Limits.maxListEntries(); entries > limit {
+		return &LimitExceededError{Kind: "list entries", Limit: int64(limit)}
+	}
+	return nil
+}
+
+// checkPages enforces the MaxPages limit before fetching the given page,
+// counting from one.
+func (registry *Registry) checkPages(page int) error {
+	if limit := registry.Limits.maxPages(); page > limit {
+		return &LimitExceededError{Kind: "pages", Limit: int64(limit)}
+	}
+	return nil
+}
+
+// ImageManifests returns the image manifests the index with the given
+// reference refers to, following nested indexes up to the MaxIndexDepth
+// limit. If the reference is that of an image manifest, its own descriptor
+// is returned. Annotations and platforms of nested index entries are those
+// of the innermost entry.
+func (registry *Registry) ImageManifests(repository, reference string) ([]manifestlist.ManifestDescriptor, error) {
+	payload, contentType, err := registry.fetchManifest(repository, reference, manifestMediaTypes...)
+	if err != nil {
+		return nil, err
+	}
+	if !isIndexMediaType(contentType) {
+		var document manifestDocument
+		if err := json.Unmarshal(payload, &document); err != nil {
+			return nil, fmt.Errorf("invalid manifest %s: %w", reference, err)
+		}
+		mediaType := document.MediaType
+		if parsed, _, err := mime.ParseMediaType(contentType); err == nil && parsed != "" {
+			mediaType = parsed
+		}
+		descriptor := manifestlist.ManifestDescriptor{}
+		descriptor.MediaType = mediaType
+		descriptor.Size = int64(len(payload))
+		descriptor.Digest = digest.FromBytes(payload)
+		return []manifestlist.ManifestDescriptor{descriptor}, nil
+	}
+
+	index, err := registry.decodeIndex(reference, payload)
+	if err != nil {
+		return nil, err
+	}
+	var manifests []manifestlist.ManifestDescriptor
+	if err := registry.collectImageManifests(repository, index, 1, &manifests); err != nil {
+		return nil, err
+	}
+	return manifests, nil
+}
+
+func (registry *Registry) collectImageManifests(repository string, index Index, depth int, manifests *[]manifestlist.ManifestDescriptor) error {
+	limit := registry.Limits.maxIndexEntries()
+	for _, entry := range index.Manifests {
+		if !isIndexMediaType(entry.MediaType) {
+			if len(*manifests) >= limit {
+				return &LimitExceededError{Kind: "index entries", Limit: int64(limit)}
+			}
+			*manifests = append(*manifests, entry)
+			continue
+		}
+
+		if maxDepth := registry.Limits.maxIndexDepth(); depth >= maxDepth {
+			return &LimitExceededError{Kind: "index depth", Limit: int64(maxDepth)}
+		}
+		nested, err := registry.fetchIndex(repository, entry.Digest.String())
+		if err != nil {
+			return err
+		}
+		if err := registry.collectImageManifests(repository, nested, depth+1, manifests); err != nil {
+			return err
+		}
+	}
+	return nil
+}
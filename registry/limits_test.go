@@ -0,0 +1,192 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestManifestSizeLimit(t *testing.T) {
+	_, r := newFakeRegistry(t)
+	config := `{"architecture":"amd64","os":"linux"}`
+	d := pushTestImage(t, r, "test/image", config)
+
+	r.Limits.MaxManifestSize = 16
+	_, err := r.ManifestOCI("test/image", d.String())
+	var limitErr *LimitExceededError
+	if !errors.As(err, &limitErr) || limitErr.Kind != "manifest" || limitErr.Limit != 16 {
+		t.Errorf("Expected a manifest LimitExceededError but got: %v", err)
+	}
+
+	r.Limits = Limits{MaxConfigSize: 8}
+	if _, err := r.Metadata("test/image", d.String()); !errors.As(err, &limitErr) || limitErr.Kind != "blob" {
+		t.Errorf("Expected a blob LimitExceededError but got: %v", err)
+	}
+
+	r.Limits = Limits{MaxResponseSize: 8}
+	if _, err := r.Tags("test/image"); !errors.As(err, &limitErr) || limitErr.Kind != "response" {
+		t.Errorf("Expected a response LimitExceededError but got: %v", err)
+	}
+}
+
+func TestImageManifestsLimits(t *testing.T) {
+	_, r := newFakeRegistry(t)
+	amd64 := pushTestImage(t, r, "test/image", `{"architecture":"amd64","os":"linux"}`)
+	arm64 := pushTestImage(t, r, "test/image", `{"architecture":"arm64","os":"linux"}`)
+	platform := &manifestlist.PlatformSpec{Architecture: "amd64", OS: "linux"}
+
+	inner, err := r.PushIndex("test/image", "inner", MediaTypeImageIndex, []IndexEntry{{Digest: amd64}, {Digest: arm64}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outer, err := r.PushIndex("test/image", "outer", MediaTypeImageIndex, []IndexEntry{{Digest: inner, Platform: platform}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifests, err := r.ImageManifests("test/image", outer.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifests) != 2 || manifests[0].Digest != amd64 || manifests[1].Digest != arm64 {
+		t.Errorf("Unexpected manifests: %+v", manifests)
+	}
+
+	manifests, err = r.ImageManifests("test/image", amd64.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifests) != 1 || manifests[0].Digest != amd64 || manifests[0].MediaType != MediaTypeImageManifest {
+		t.Errorf("Unexpected manifests for an image: %+v", manifests)
+	}
+
+	var limitErr *LimitExceededError
+	r.Limits.MaxIndexDepth = 1
+	if _, err := r.ImageManifests("test/image", outer.String()); !errors.As(err, &limitErr) || limitErr.Kind != "index depth" {
+		t.Errorf("Expected an index depth LimitExceededError but got: %v", err)
+	}
+
+	r.Limits = Limits{MaxIndexEntries: 1}
+	if _, err := r.ImageManifests("test/image", outer.String()); !errors.As(err, &limitErr) || limitErr.Kind != "index entries" {
+		t.Errorf("Expected an index entries LimitExceededError but got: %v", err)
+	}
+	if _, err := r.ImageIndex("test/image", inner.String()); !errors.As(err, &limitErr) || limitErr.Kind != "index entries" {
+		t.Errorf("Expected an index entries LimitExceededError from ImageIndex but got: %v", err)
+	}
+}
+
+func TestTransportLimits(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			_, _ = w.Write([]byte(`{"token":"` + strings.Repeat("a", 1024) + `"}`))
+			return
+		}
+		if r.URL.Path == "/v2/" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="http://`+r.Host+`/token",service="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(strings.Repeat("x", 1024)))
+	}))
+	defer s.Close()
+
+	transport := &ErrorTransport{Transport: &BasicTransport{Transport: &TokenTransport{Transport: http.DefaultTransport, MaxTokenSize: 512}, URL: s.URL}, MaxBodySize: 100}
+	r, err := NewFromTransport(s.URL, transport, Quiet)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var limitErr *LimitExceededError
+	if err := r.Ping(); !errors.As(err, &limitErr) || limitErr.Kind != "token response" {
+		t.Errorf("Expected a token response LimitExceededError but got: %v", err)
+	}
+
+	_, err = r.DownloadLayer("test/image", digest.FromString("missing"))
+	httpErr, ok := httpStatusError(err)
+	if !ok || len(httpErr.Body) != 100 {
+		t.Errorf("Expected an error body truncated to 100 bytes but got: %v", err)
+	}
+
+	r, err = New(s.URL, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Logf = Quiet
+	r.Limits = Limits{MaxTokenSize: 512, MaxErrorBodySize: 50}
+	if err := r.Ping(); !errors.As(err, &limitErr) || limitErr.Kind != "token response" {
+		t.Errorf("Expected a token response LimitExceededError through Registry.Limits but got: %v", err)
+	}
+	_, err = r.DownloadLayer("test/image", digest.FromString("missing"))
+	if httpErr, ok := httpStatusError(err); !ok || len(httpErr.Body) != 50 {
+		t.Errorf("Expected an error body truncated to 50 bytes through Registry.Limits but got: %v", err)
+	}
+}
+
+func TestPaginationLimits(t *testing.T) {
+	subject := digest.FromString("subject")
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Every page links to yet another one.
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		w.Header().Set("Link", fmt.Sprintf(`<http://%s%s?page=%d>; rel="next"`, r.Host, r.URL.Path, page+1))
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/tags/list"):
+			_ = json.NewEncoder(w).Encode(tagsResponse{Tags: []string{fmt.Sprint("tag", page)}})
+		case r.URL.Path == "/v2/_catalog":
+			_ = json.NewEncoder(w).Encode(repositoriesResponse{Repositories: []string{fmt.Sprint("repository", page)}})
+		case strings.Contains(r.URL.Path, "/referrers/"):
+			_ = json.NewEncoder(w).Encode(referrersIndex{Manifests: []ArtifactDescriptor{{
+				Descriptor: distribution.Descriptor{MediaType: MediaTypeImageManifest, Digest: digest.FromString(fmt.Sprint(page)), Size: 1},
+			}}})
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer s.Close()
+
+	r, err := New(s.URL, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Logf = Quiet
+
+	list := map[string]func() error{
+		"tags": func() error {
+			_, err := r.Tags("test/image")
+			return err
+		},
+		"repositories": func() error {
+			_, err := r.Repositories()
+			return err
+		},
+		"referrers": func() error {
+			_, err := r.Referrers("test/image", subject, "")
+			return err
+		},
+	}
+	expectLimit := func(name, kind string) {
+		var limitErr *LimitExceededError
+		if err := list[name](); !errors.As(err, &limitErr) || limitErr.Kind != kind {
+			t.Errorf("Expected a %s LimitExceededError listing %s but got: %v", kind, name, err)
+		}
+	}
+
+	r.Limits = Limits{MaxPages: 5}
+	for name := range list {
+		expectLimit(name, "pages")
+	}
+
+	r.Limits = Limits{MaxListEntries: 3, MaxIndexEntries: 3}
+	expectLimit("tags", "list entries")
+	expectLimit("repositories", "list entries")
+	expectLimit("referrers", "index entries")
+}
@@ -3,8 +3,9 @@ package registry
 import (
 	"bytes"
 	"fmt"
-	"io"
+	"mime"
 	"net/http"
+	"strings"
 
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/manifest/manifestlist"
@@ -22,6 +23,18 @@ const (
 	MediaTypeImageManifest = "application/vnd.oci.image.manifest.v1+json"
 	// MediaTypeImageIndex specifies the media type for an image index.
 	MediaTypeImageIndex = "application/vnd.oci.image.index.v1+json"
+	// MediaTypeImageConfig specifies the media type for an image configuration.
+	MediaTypeImageConfig = "application/vnd.oci.image.config.v1+json"
+	// MediaTypeImageLayer specifies the media type for an uncompressed layer.
+	MediaTypeImageLayer = "application/vnd.oci.image.layer.v1.tar"
+	// MediaTypeImageLayerGzip specifies the media type for a gzip compressed layer.
+	MediaTypeImageLayerGzip = "application/vnd.oci.image.layer.v1.tar+gzip"
+	// MediaTypeImageLayerNonDistributableGzip specifies the media type for a gzip compressed
+	// layer which must not be pushed to other registries.
+	MediaTypeImageLayerNonDistributableGzip = "application/vnd.oci.image.layer.nondistributable.v1.tar+gzip"
+	// MediaTypeEmptyJSON specifies the media type for the empty JSON object `{}`, used as
+	// the config of artifacts which have none.
+	MediaTypeEmptyJSON = "application/vnd.oci.empty.v1+json"
 )
 
 func (registry *Registry) Manifest(repository, reference string) (*schema1.SignedManifest, error) {
@@ -48,7 +61,7 @@ func (registry *Registry) ManifestList(repository, reference string) (*manifestl
 	}
 
 	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+	body, err := registry.readManifest(resp.Body)
 	if err != nil {
 		return nil, err
 	}
@@ -58,6 +71,9 @@ func (registry *Registry) ManifestList(repository, reference string) (*manifestl
 	if err != nil {
 		return nil, err
 	}
+	if err := registry.checkIndexEntries(len(deserialized.Manifests)); err != nil {
+		return nil, err
+	}
 	return deserialized, nil
 }
 
@@ -77,7 +93,7 @@ func (registry *Registry) v1Manifest(repository, reference string, mediaType str
 	}
 
 	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+	body, err := registry.readManifest(resp.Body)
 	if err != nil {
 		return nil, err
 	}
@@ -115,7 +131,7 @@ func (registry *Registry) ManifestV2WithDigest(repository, reference string) (*s
 	}
 
 	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+	body, err := registry.readManifest(resp.Body)
 	if err != nil {
 		return nil, "", err
 	}
@@ -149,7 +165,7 @@ func (registry *Registry) ImageIndex(repository, reference string) (*manifestlis
 	}
 
 	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+	body, err := registry.readManifest(resp.Body)
 	if err != nil {
 		return nil, err
 	}
@@ -159,6 +175,9 @@ func (registry *Registry) ImageIndex(repository, reference string) (*manifestlis
 	if err != nil {
 		return nil, err
 	}
+	if err := registry.checkIndexEntries(len(deserialized.Manifests)); err != nil {
+		return nil, err
+	}
 	return deserialized, nil
 }
 
@@ -186,7 +205,7 @@ func (registry *Registry) ManifestOCIWithDigest(repository, reference string) (*
 	}
 
 	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+	body, err := registry.readManifest(resp.Body)
 	if err != nil {
 		return nil, "", err
 	}
@@ -214,12 +233,9 @@ func (registry *Registry) ManifestDigest(repository, reference string) (digest.D
 		return "", "", err
 	}
 
-	req.Header.Add("Accept", schema2.MediaTypeManifest)
-	req.Header.Add("Accept", schema1.MediaTypeManifest)
-	req.Header.Add("Accept", schema1.MediaTypeSignedManifest)
-	req.Header.Add("Accept", manifestlist.MediaTypeManifestList)
-	req.Header.Add("Accept", MediaTypeImageManifest)
-	req.Header.Add("Accept", MediaTypeImageIndex)
+	for _, mediaType := range manifestMediaTypes {
+		req.Header.Add("Accept", mediaType)
+	}
 
 	resp, err := registry.Client.Do(req)
 	if resp != nil {
@@ -239,42 +255,227 @@ func (registry *Registry) DeleteManifest(repository string, digest digest.Digest
 		return fmt.Errorf("invalid layer digest %v: %w", digest, err)
 	}
 
-	url := registry.url("/v2/%s/manifests/%s", repository, digest)
-	registry.Logf("registry.manifest.delete url=%s repository=%s reference=%s", url, repository, digest)
+	return registry.deleteManifest(repository, digest.String())
+}
+
+func (registry *Registry) PutManifest(repository, reference string, manifest distribution.Manifest) error {
+	_, err := registry.PutManifestWithDescriptor(repository, reference, manifest, PutManifestOptions{})
+	return err
+}
+
+// PushedManifest describes a manifest accepted by the registry.
+type PushedManifest struct {
+	distribution.Descriptor
+	// Location is the URL of the manifest as reported by the registry, if any.
+	Location string
+	// Subject is the digest from the OCI-Subject header, which registries supporting
+	// the referrers API set when the pushed manifest has a subject.
+	Subject digest.Digest
+}
+
+// PutManifestOptions controls how PutManifestWithDescriptor pushes a manifest.
+type PutManifestOptions struct {
+	// CheckReferences verifies that everything the manifest refers to exists
+	// in the repository before pushing it, so that the push does not fail
+	// with MANIFEST_BLOB_UNKNOWN. Blobs (config and layers) are checked with
+	// HasLayer and the manifests referenced by an index are checked by
+	// digest. Foreign layers, which are not served by the registry, are
+	// skipped. If anything is missing, nothing is pushed and a
+	// *MissingReferencesError is returned.
+	CheckReferences bool
+}
+
+// PutManifestWithDescriptor extends PutManifest to return the descriptor of the pushed manifest.
+// If the registry reports a Docker-Content-Digest which does not match the digest of the
+// payload, an error wrapping ErrDigestMismatch is returned.
+func (registry *Registry) PutManifestWithDescriptor(repository, reference string, manifest distribution.Manifest, options PutManifestOptions) (PushedManifest, error) {
+	url := registry.url("/v2/%s/manifests/%s", repository, reference)
+
+	mediaType, payload, err := manifest.Payload()
+	if err != nil {
+		return PushedManifest{}, err
+	}
+	if options.CheckReferences {
+		if err := registry.checkManifestReferences(repository, mediaType, manifest); err != nil {
+			return PushedManifest{}, err
+		}
+	}
+
+	registry.Logf("registry.manifest.put url=%s repository=%s reference=%s", url, repository, reference)
 
-	req, err := http.NewRequest("DELETE", url, nil)
+	buffer := bytes.NewBuffer(payload)
+	req, err := http.NewRequest("PUT", url, buffer)
 	if err != nil {
-		return err
+		return PushedManifest{}, err
 	}
+
+	req.Header.Set("Content-Type", mediaType)
 	resp, err := registry.Client.Do(req)
 	if resp != nil {
 		defer resp.Body.Close()
 	}
 	if err != nil {
-		return err
+		return PushedManifest{}, err
+	}
+
+	pushed := PushedManifest{
+		Descriptor: distribution.Descriptor{
+			MediaType: mediaType,
+			Size:      int64(len(payload)),
+			Digest:    digest.FromBytes(payload),
+		},
+		Subject: digest.Digest(resp.Header.Get("OCI-Subject")),
+	}
+	// The Location header may be relative to the registry.
+	if location := resp.Header.Get("Location"); location != "" {
+		if locationUrl, err := registry.resolveLocation(resp, location); err == nil {
+			pushed.Location = locationUrl.String()
+		}
+	}
+	if returned, err := digest.Parse(resp.Header.Get("Docker-Content-Digest")); err == nil {
+		if expected := returned.Algorithm().FromBytes(payload); returned != expected {
+			return pushed, fmt.Errorf("%w: pushed manifest %s but registry returned %s", ErrDigestMismatch, expected, returned)
+		}
+	}
+	return pushed, nil
+}
+
+// MissingReferencesError is returned by PutManifestWithDescriptor when the
+// repository lacks some of the content a manifest refers to.
+type MissingReferencesError struct {
+	Missing []distribution.Descriptor
+}
+
+func (err *MissingReferencesError) Error() string {
+	digests := make([]string, len(err.Missing))
+	for i, descriptor := range err.Missing {
+		digests[i] = descriptor.Digest.String()
+	}
+	return fmt.Sprintf("repository is missing %d referenced items: %s", len(err.Missing), strings.Join(digests, ", "))
+}
+
+// checkManifestReferences returns a *MissingReferencesError if the repository
+// lacks anything the manifest refers to, as described for
+// PutManifestOptions.CheckReferences.
+func (registry *Registry) checkManifestReferences(repository, mediaType string, manifest distribution.Manifest) error {
+	isIndex := mediaType == MediaTypeImageIndex || mediaType == manifestlist.MediaTypeManifestList
+
+	var missing []distribution.Descriptor
+	for _, reference := range manifest.References() {
+		if isForeignLayer(reference) {
+			continue
+		}
+
+		var exists bool
+		var err error
+		if isIndex {
+			var status ManifestStatus
+			status, err = registry.HasManifest(repository, reference.Digest.String())
+			exists = status.Exists
+		} else {
+			exists, err = registry.HasLayer(repository, reference.Digest)
+		}
+		if err != nil {
+			return err
+		}
+		if !exists {
+			missing = append(missing, reference)
+		}
+	}
+
+	if len(missing) > 0 {
+		return &MissingReferencesError{Missing: missing}
 	}
 	return nil
 }
 
-func (registry *Registry) PutManifest(repository, reference string, manifest distribution.Manifest) error {
+// manifestMediaTypes lists every manifest media type understood by this library.
+var manifestMediaTypes = []string{
+	schema2.MediaTypeManifest,
+	schema1.MediaTypeManifest,
+	schema1.MediaTypeSignedManifest,
+	manifestlist.MediaTypeManifestList,
+	MediaTypeImageManifest,
+	MediaTypeImageIndex,
+}
+
+// ManifestResponse describes the response a manifest was served with.
+type ManifestResponse struct {
+	// MediaType is the Content-Type without any parameters.
+	MediaType     string
+	ContentType   string
+	ContentLength int64
+	// Digest is computed from the payload, with the algorithm of the
+	// Docker-Content-Digest header or of the reference if they have one.
+	Digest digest.Digest
+	// HeaderDigest is the Docker-Content-Digest header, which is empty if the
+	// registry did not send a valid one.
+	HeaderDigest digest.Digest
+	ETag         string
+	// APIVersion is the Docker-Distribution-API-Version header.
+	APIVersion string
+	// Header holds every response header.
+	Header http.Header
+}
+
+// FetchManifestRaw returns the exact payload of a manifest, in whichever of
+// the accepted formats the registry chooses to serve, together with details
+// of the response. If no accept types are given, every manifest media type
+// understood by this library is accepted. Unlike the typed fetchers, the
+// payload can be pushed again byte for byte with the same digest, by passing
+// RawManifest(response.MediaType, payload) to PutManifestWithDescriptor.
+func (registry *Registry) FetchManifestRaw(repository, reference string, acceptTypes []string) ([]byte, ManifestResponse, error) {
+	if len(acceptTypes) == 0 {
+		acceptTypes = manifestMediaTypes
+	}
+
 	url := registry.url("/v2/%s/manifests/%s", repository, reference)
-	registry.Logf("registry.manifest.put url=%s repository=%s reference=%s", url, repository, reference)
+	registry.Logf("registry.manifest.get url=%s repository=%s reference=%s", url, repository, reference)
 
-	mediaType, payload, err := manifest.Payload()
+	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return err
+		return nil, ManifestResponse{}, err
 	}
 
-	buffer := bytes.NewBuffer(payload)
-	req, err := http.NewRequest("PUT", url, buffer)
+	for _, acceptType := range acceptTypes {
+		req.Header.Add("Accept", acceptType)
+	}
+	resp, err := registry.Client.Do(req)
 	if err != nil {
-		return err
+		return nil, ManifestResponse{}, err
 	}
 
-	req.Header.Set("Content-Type", mediaType)
-	resp, err := registry.Client.Do(req)
-	if resp != nil {
-		defer resp.Body.Close()
+	defer resp.Body.Close()
+	body, err := registry.readManifest(resp.Body)
+	if err != nil {
+		return nil, ManifestResponse{}, err
 	}
-	return err
+
+	response := ManifestResponse{
+		ContentType:   resp.Header.Get("Content-Type"),
+		ContentLength: resp.ContentLength,
+		ETag:          resp.Header.Get("ETag"),
+		APIVersion:    resp.Header.Get("Docker-Distribution-API-Version"),
+		Header:        resp.Header,
+	}
+	if mediaType, _, err := mime.ParseMediaType(response.ContentType); err == nil {
+		response.MediaType = mediaType
+	}
+	algorithm := digest.Canonical
+	if d, err := digest.Parse(reference); err == nil {
+		algorithm = d.Algorithm()
+	}
+	if d, err := digest.Parse(resp.Header.Get("Docker-Content-Digest")); err == nil {
+		response.HeaderDigest = d
+		algorithm = d.Algorithm()
+	}
+	response.Digest = algorithm.FromBytes(body)
+	return body, response, nil
+}
+
+// fetchManifest returns the payload and Content-Type of a manifest in whichever of the
+// accepted formats the registry chooses to serve.
+func (registry *Registry) fetchManifest(repository, reference string, acceptTypes ...string) ([]byte, string, error) {
+	body, response, err := registry.FetchManifestRaw(repository, reference, acceptTypes)
+	return body, response.ContentType, err
 }
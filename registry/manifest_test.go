@@ -1,12 +1,17 @@
 package registry
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
 
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/google/go-cmp/cmp"
 	"github.com/opencontainers/go-digest"
 )
 
@@ -117,3 +122,137 @@ func TestManifestOCIWithDigest(t *testing.T) {
 		t.Errorf("Expected digest %q but got: %q", fakeDigest, digest)
 	}
 }
+
+func TestPutManifestWithDescriptor(t *testing.T) {
+	_, r := newFakeRegistry(t)
+
+	config := []byte(`{}`)
+	layer := []byte("layer")
+	m, err := schema2.FromStruct(schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		Config:    distribution.Descriptor{MediaType: schema2.MediaTypeImageConfig, Size: int64(len(config)), Digest: digest.FromBytes(config)},
+		Layers:    []distribution.Descriptor{{MediaType: schema2.MediaTypeLayer, Size: int64(len(layer)), Digest: digest.FromBytes(layer)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checked := PutManifestOptions{CheckReferences: true}
+	_, err = r.PutManifestWithDescriptor("test/image", "latest", m, checked)
+	var missingErr *MissingReferencesError
+	if !errors.As(err, &missingErr) || len(missingErr.Missing) != 2 {
+		t.Fatalf("Expected both blobs to be reported missing but got: %v", err)
+	}
+	if _, _, err := r.ManifestDigest("test/image", "latest"); err == nil {
+		t.Error("Expected nothing to be pushed when references are missing")
+	}
+
+	if err := r.UploadLayer("test/image", digest.FromBytes(config), bytes.NewReader(config)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.UploadLayer("test/image", digest.FromBytes(layer), bytes.NewReader(layer)); err != nil {
+		t.Fatal(err)
+	}
+	pushed, err := r.PutManifestWithDescriptor("test/image", "latest", m, checked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, payload, _ := m.Payload()
+	if pushed.Digest != digest.FromBytes(payload) {
+		t.Errorf("Expected digest %s but got: %s", digest.FromBytes(payload), pushed.Digest)
+	}
+	if pushed.MediaType != schema2.MediaTypeManifest || pushed.Size != int64(len(payload)) {
+		t.Errorf("Unexpected descriptor: %+v", pushed.Descriptor)
+	}
+	if pushed.Location != r.URL+"/v2/test/image/manifests/latest" {
+		t.Errorf("Unexpected location: %q", pushed.Location)
+	}
+}
+
+func TestPutManifestDigestMismatch(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Docker-Content-Digest", fakeDigest.String())
+		w.WriteHeader(http.StatusCreated)
+	}))
+	t.Cleanup(s.Close)
+
+	r, err := NewInsecure(s.URL, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Logf = Quiet
+
+	m, err := schema2.FromStruct(schema2.Manifest{Versioned: schema2.SchemaVersion})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.PutManifestWithDescriptor("test/image", "latest", m, PutManifestOptions{}); !errors.Is(err, ErrDigestMismatch) {
+		t.Errorf("Expected ErrDigestMismatch but got: %v", err)
+	}
+}
+
+func TestFetchManifestRaw(t *testing.T) {
+	payload := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{},"layers":[]}`)
+	d := digest.FromBytes(payload)
+	var accept []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accept = r.Header.Values("Accept")
+		w.Header().Set("Content-Type", MediaTypeImageManifest+"; charset=utf-8")
+		w.Header().Set("Docker-Content-Digest", d.String())
+		w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+		w.Header().Set("ETag", `"`+d.String()+`"`)
+		_, _ = w.Write(payload)
+	}))
+	t.Cleanup(s.Close)
+
+	r, err := NewInsecure(s.URL, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Logf = Quiet
+
+	body, response, err := r.FetchManifestRaw("test/image", "latest", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, payload) {
+		t.Errorf("Expected the exact payload but got: %s", body)
+	}
+	if len(accept) != len(manifestMediaTypes) {
+		t.Errorf("Expected every manifest media type to be accepted but got: %v", accept)
+	}
+	expected := ManifestResponse{
+		MediaType:     MediaTypeImageManifest,
+		ContentType:   MediaTypeImageManifest + "; charset=utf-8",
+		ContentLength: int64(len(payload)),
+		Digest:        d,
+		HeaderDigest:  d,
+		ETag:          `"` + d.String() + `"`,
+		APIVersion:    "registry/2.0",
+	}
+	response.Header = nil
+	if diff := cmp.Diff(expected, response); diff != "" {
+		t.Errorf("Unexpected response (-want +got):\n%s", diff)
+	}
+}
+
+func TestRawManifestRoundTrip(t *testing.T) {
+	_, r := newFakeRegistry(t)
+	d := pushTestImage(t, r, "test/image", `{"architecture":"amd64","os":"linux"}`)
+
+	payload, response, err := r.FetchManifestRaw("test/image", d.String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := RawManifest(response.MediaType, payload)
+	if references := m.References(); len(references) != 1 || references[0].MediaType != MediaTypeImageConfig {
+		t.Errorf("Expected the config as the only reference but got: %+v", references)
+	}
+	pushed, err := r.PutManifestWithDescriptor("test/copy", "latest", m, PutManifestOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pushed.Digest != d || pushed.MediaType != response.MediaType {
+		t.Errorf("Expected the manifest to be pushed as %s but got: %+v", d, pushed.Descriptor)
+	}
+}
@@ -0,0 +1,160 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/opencontainers/go-digest"
+)
+
+// Pre-defined annotation keys of the OCI image spec, which are also used as
+// config labels.
+const (
+	AnnotationCreated         = "org.opencontainers.image.created"
+	AnnotationAuthors         = "org.opencontainers.image.authors"
+	AnnotationURL             = "org.opencontainers.image.url"
+	AnnotationDocumentation   = "org.opencontainers.image.documentation"
+	AnnotationSource          = "org.opencontainers.image.source"
+	AnnotationVersion         = "org.opencontainers.image.version"
+	AnnotationRevision        = "org.opencontainers.image.revision"
+	AnnotationVendor          = "org.opencontainers.image.vendor"
+	AnnotationLicenses        = "org.opencontainers.image.licenses"
+	AnnotationRefName         = "org.opencontainers.image.ref.name"
+	AnnotationTitle           = "org.opencontainers.image.title"
+	AnnotationDescription     = "org.opencontainers.image.description"
+	AnnotationBaseImageName   = "org.opencontainers.image.base.name"
+	AnnotationBaseImageDigest = "org.opencontainers.image.base.digest"
+)
+
+// ImageMetadata is a typed view of the org.opencontainers.image.* metadata
+// of an image, merged from its manifest annotations, the annotations of the
+// index entry pointing at it and its config labels.
+//
+// Values which cannot be parsed leave the typed field at its zero value; the
+// unparsed value remains available in Values.
+type ImageMetadata struct {
+	Created       time.Time
+	Authors       string
+	URL           string
+	Documentation string
+	Source        string
+	Version       string
+	Revision      string
+	Vendor        string
+	Licenses      string
+	RefName       string
+	Title         string
+	Description   string
+	// BaseName is the reference of the image this image is based on.
+	BaseName reference.Named
+	// BaseDigest is the digest of the manifest of the base image.
+	BaseDigest digest.Digest
+
+	// Values holds every merged annotation and label, including ones not
+	// covered by the fields above.
+	Values map[string]string
+}
+
+// NewImageMetadata merges the given maps, any of which may be nil, and parses
+// the result. Manifest annotations take precedence over index entry
+// annotations, which take precedence over config labels. The config creation
+// time is used as Created if no annotation or label provides one.
+func NewImageMetadata(manifestAnnotations, indexAnnotations, configLabels map[string]string, configCreated time.Time) ImageMetadata {
+	values := make(map[string]string)
+	for _, source := range []map[string]string{configLabels, indexAnnotations, manifestAnnotations} {
+		for key, value := range source {
+			values[key] = value
+		}
+	}
+
+	metadata := ImageMetadata{
+		Authors:       values[AnnotationAuthors],
+		URL:           values[AnnotationURL],
+		Documentation: values[AnnotationDocumentation],
+		Source:        values[AnnotationSource],
+		Version:       values[AnnotationVersion],
+		Revision:      values[AnnotationRevision],
+		Vendor:        values[AnnotationVendor],
+		Licenses:      values[AnnotationLicenses],
+		RefName:       values[AnnotationRefName],
+		Title:         values[AnnotationTitle],
+		Description:   values[AnnotationDescription],
+		Values:        values,
+	}
+
+	metadata.Created = configCreated
+	if created, ok := values[AnnotationCreated]; ok {
+		if parsed, err := time.Parse(time.RFC3339, created); err == nil {
+			metadata.Created = parsed
+		}
+	}
+	if name, ok := values[AnnotationBaseImageName]; ok {
+		if parsed, err := reference.ParseNormalizedNamed(name); err == nil {
+			metadata.BaseName = parsed
+		}
+	}
+	if d, ok := values[AnnotationBaseImageDigest]; ok {
+		if parsed, err := digest.Parse(d); err == nil {
+			metadata.BaseDigest = parsed
+		}
+	}
+	return metadata
+}
+
+// configMetadata holds the parts of an image config ImageMetadata is built from.
+type configMetadata struct {
+	Created time.Time `json:"created"`
+	Config  struct {
+		Labels map[string]string `json:"Labels"`
+	} `json:"config"`
+}
+
+// manifestAnnotations holds the annotations of a manifest or index.
+type manifestAnnotations struct {
+	Annotations map[string]string `json:"annotations"`
+}
+
+// Metadata returns the merged metadata of the manifest or index with the
+// given reference. For an image manifest, its annotations and config labels
+// are used; for an index, only its own annotations.
+func (registry *Registry) Metadata(repository, reference string) (ImageMetadata, error) {
+	return registry.metadata(repository, reference, nil)
+}
+
+// EntryMetadata returns the merged metadata of the manifest an index entry
+// points to, including the annotations of the entry itself.
+func (registry *Registry) EntryMetadata(repository string, entry manifestlist.ManifestDescriptor) (ImageMetadata, error) {
+	return registry.metadata(repository, entry.Digest.String(), entry.Annotations)
+}
+
+func (registry *Registry) metadata(repository, reference string, indexAnnotations map[string]string) (ImageMetadata, error) {
+	payload, _, err := registry.fetchManifest(repository, reference, manifestMediaTypes...)
+	if err != nil {
+		return ImageMetadata{}, err
+	}
+
+	var annotations manifestAnnotations
+	if err := json.Unmarshal(payload, &annotations); err != nil {
+		return ImageMetadata{}, fmt.Errorf("invalid manifest %s: %w", reference, err)
+	}
+	var document manifestDocument
+	if err := json.Unmarshal(payload, &document); err != nil {
+		return ImageMetadata{}, fmt.Errorf("invalid manifest %s: %w", reference, err)
+	}
+
+	var config configMetadata
+	if document.Config != nil && document.Config.MediaType != MediaTypeEmptyJSON {
+		blob, err := registry.fetchBlob(repository, *document.Config)
+		if err != nil {
+			return ImageMetadata{}, err
+		}
+		if err := json.Unmarshal(blob, &config); err != nil {
+			return ImageMetadata{}, fmt.Errorf("invalid image config %s: %w", document.Config.Digest, err)
+		}
+	}
+
+	return NewImageMetadata(annotations.Annotations, indexAnnotations, config.Config.Labels, config.Created), nil
+}
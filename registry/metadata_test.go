@@ -0,0 +1,55 @@
+package registry
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewImageMetadata(t *testing.T) {
+	configCreated := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	metadata := NewImageMetadata(
+		map[string]string{
+			AnnotationVersion: "2.0",
+			AnnotationCreated: "2024-05-01T12:00:00Z",
+		},
+		map[string]string{
+			AnnotationVersion:  "1.5",
+			AnnotationRevision: "abc123",
+		},
+		map[string]string{
+			AnnotationVersion:         "1.0",
+			AnnotationSource:          "https://github.com/example/image",
+			AnnotationBaseImageName:   "alpine:3.19",
+			AnnotationBaseImageDigest: fakeDigest.String(),
+			"com.example.custom":      "value",
+		},
+		configCreated,
+	)
+
+	if metadata.Version != "2.0" {
+		t.Errorf("Expected manifest annotations to take precedence, got version %q", metadata.Version)
+	}
+	if metadata.Revision != "abc123" {
+		t.Errorf("Expected index annotations to take precedence over labels, got revision %q", metadata.Revision)
+	}
+	if metadata.Source != "https://github.com/example/image" {
+		t.Errorf("Expected source from labels, got %q", metadata.Source)
+	}
+	if want := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC); !metadata.Created.Equal(want) {
+		t.Errorf("Expected created %v but got: %v", want, metadata.Created)
+	}
+	if metadata.BaseName == nil || metadata.BaseName.String() != "docker.io/library/alpine:3.19" {
+		t.Errorf("Unexpected base name: %v", metadata.BaseName)
+	}
+	if metadata.BaseDigest != fakeDigest {
+		t.Errorf("Expected base digest %s but got: %s", fakeDigest, metadata.BaseDigest)
+	}
+	if metadata.Values["com.example.custom"] != "value" {
+		t.Errorf("Expected custom label to be kept, got: %v", metadata.Values)
+	}
+
+	metadata = NewImageMetadata(nil, nil, map[string]string{AnnotationCreated: "yesterday"}, configCreated)
+	if !metadata.Created.Equal(configCreated) {
+		t.Errorf("Expected config creation time for an invalid annotation but got: %v", metadata.Created)
+	}
+}
@@ -0,0 +1,114 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// maxRedirects is the number of redirects followed for a single request, as
+// with the default policy of http.Client.
+const maxRedirects = 10
+
+// checkRedirect is the redirect policy of the http.Client of a Registry.
+// Registries commonly redirect blob requests to signed URLs of a storage
+// service; registry credentials are not sent along when the redirect leaves
+// the host of the original request, since the signature in the URL grants
+// access by itself.
+func (registry *Registry) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	crossHost := redirectedAcrossHosts(req)
+	if crossHost {
+		req.Header.Del("Authorization")
+	}
+	registry.Logf("registry.redirect from=%s to=%s cross-host=%v", redactURL(via[len(via)-1]), redactURL(req), crossHost)
+	return nil
+}
+
+// redirectedAcrossHosts reports whether req was created by following a
+// redirect to a different scheme or host than the request which started the
+// chain. Transports must not add registry credentials to such requests.
+func redirectedAcrossHosts(req *http.Request) bool {
+	original := req
+	for original.Response != nil && original.Response.Request != nil {
+		original = original.Response.Request
+	}
+	return original != req &&
+		(original.URL.Scheme != req.URL.Scheme || !strings.EqualFold(original.URL.Host, req.URL.Host))
+}
+
+// redactURL returns the URL of req without its query, which holds the
+// signature of signed storage URLs.
+func redactURL(req *http.Request) string {
+	u := *req.URL
+	u.RawQuery = ""
+	u.User = nil
+	return u.String()
+}
+
+// isExpiredRedirect reports whether err is a rejection of a request that was
+// redirected away from the registry, as happens when a signed storage URL
+// expires. Requesting the blob from the registry again yields a fresh one.
+func isExpiredRedirect(err error) bool {
+	httpErr, ok := httpStatusError(err)
+	if !ok || httpErr.Response.Request == nil || !redirectedAcrossHosts(httpErr.Response.Request) {
+		return false
+	}
+	switch httpErr.Response.StatusCode {
+	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden:
+		return true
+	}
+	return false
+}
+
+// getBlob requests a blob, or the given range of it if byteRange is set to
+// the value of a Range header. If the registry redirects to a signed URL
+// which turns out to have expired, the blob is requested from the registry
+// once more. Every GET of a blob goes through getBlob.
+func (registry *Registry) getBlob(ctx context.Context, repository string, digest digest.Digest, byteRange string) (*http.Response, error) {
+	url := registry.url("/v2/%s/blobs/%s", repository, digest)
+	if byteRange == "" {
+		registry.Logf("registry.layer.download url=%s repository=%s digest=%s", url, repository, digest)
+	}
+
+	request := func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		if byteRange != "" {
+			req.Header.Set("Range", byteRange)
+		}
+		return registry.Client.Do(req)
+	}
+
+	resp, err := request()
+	if isExpiredRedirect(err) {
+		httpErr, _ := httpStatusError(err)
+		registry.Logf("registry.layer.redirect.expired url=%s repository=%s digest=%s status=%d", redactURL(httpErr.Response.Request), repository, digest, httpErr.Response.StatusCode)
+		resp, err = request()
+	}
+	return resp, err
+}
+
+// redirectResumeOptions are used to resume a download which was redirected
+// to a signed URL and failed part way: the signature may have expired, so a
+// new one is requested from the registry for the rest of the blob.
+var redirectResumeOptions = ResumeOptions{MaxRetries: 1, InitialBackoff: 100 * time.Millisecond}
+
+// resumeRedirected makes the body of a blob response which was redirected
+// to another host resume from the registry if reading it fails.
+func (registry *Registry) resumeRedirected(repository string, digest digest.Digest, resp *http.Response) {
+	if resp.Request == nil || !redirectedAcrossHosts(resp.Request) || resp.Header.Get("Accept-Ranges") == "none" {
+		return
+	}
+	r := registry.newResumingReader(repository, digest, resp.ContentLength, redirectResumeOptions)
+	r.body = resp.Body
+	resp.Body = r
+}
@@ -0,0 +1,141 @@
+package registry
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+func TestDownloadLayerFollowsSignedRedirect(t *testing.T) {
+	content := []byte("layer content")
+	d := digest.FromBytes(content)
+
+	var mu sync.Mutex
+	var storageAuth []string
+	signatures := 0
+	expired := map[string]bool{"1": true}
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		storageAuth = append(storageAuth, r.Header.Get("Authorization"))
+		if expired[r.URL.Query().Get("signature")] {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write(content)
+	}))
+	defer storage.Close()
+
+	var registryAuth []string
+	registryServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		registryAuth = append(registryAuth, r.Header.Get("Authorization"))
+		signatures++
+		http.Redirect(w, r, fmt.Sprintf("%s/blob?signature=%d", storage.URL, signatures), http.StatusTemporaryRedirect)
+	}))
+	defer registryServer.Close()
+
+	r, err := New(registryServer.URL, "user", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logs []string
+	r.Logf = func(format string, args ...interface{}) {
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}
+
+	blob, err := r.DownloadLayer("test/image", d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := io.ReadAll(blob)
+	blob.Close()
+	if err != nil || string(actual) != string(content) {
+		t.Fatalf("Expected %q but got: %q, %v", content, actual, err)
+	}
+
+	if len(registryAuth) != 2 || registryAuth[0] == "" {
+		t.Errorf("Expected two authenticated registry requests, got %q", registryAuth)
+	}
+	if len(storageAuth) != 2 {
+		t.Errorf("Expected the expired signed URL to be replaced by a fresh one, got %d storage requests", len(storageAuth))
+	}
+	for _, auth := range storageAuth {
+		if auth != "" {
+			t.Errorf("Expected no credentials to be sent to the storage host, got %q", auth)
+		}
+	}
+
+	redirects := 0
+	for _, line := range logs {
+		if strings.HasPrefix(line, "registry.redirect ") {
+			redirects++
+			if strings.Contains(line, "signature=") {
+				t.Errorf("Expected the signature to be left out of the log: %s", line)
+			}
+		}
+	}
+	if redirects != 2 {
+		t.Errorf("Expected both redirects to be logged, got: %q", logs)
+	}
+}
+
+func TestDownloadLayerResumesExpiredRedirect(t *testing.T) {
+	content := bytes.Repeat([]byte("layer content "), 1024)
+	d := digest.FromBytes(content)
+
+	var mu sync.Mutex
+	var ranges []string
+	signatures := 0
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		mu.Unlock()
+		if r.URL.Query().Get("signature") == "1" {
+			// The signature expires part way through the download.
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			_, _ = w.Write(content[:len(content)/2])
+			return
+		}
+		http.ServeContent(w, r, "blob", time.Time{}, bytes.NewReader(content))
+	}))
+	defer storage.Close()
+
+	registryServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		signatures++
+		http.Redirect(w, r, fmt.Sprintf("%s/blob?signature=%d", storage.URL, signatures), http.StatusTemporaryRedirect)
+	}))
+	defer registryServer.Close()
+
+	r := &Registry{
+		URL:    registryServer.URL,
+		Client: &http.Client{},
+		Logf:   Quiet,
+	}
+	blob, err := r.DownloadLayer("test/image", d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := io.ReadAll(blob)
+	blob.Close()
+	if err != nil || !bytes.Equal(actual, content) {
+		t.Fatalf("Expected %d bytes of content but got %d: %v", len(content), len(actual), err)
+	}
+
+	expected := []string{"", fmt.Sprintf("bytes=%d-", len(content)/2)}
+	if len(ranges) != 2 || ranges[0] != expected[0] || ranges[1] != expected[1] {
+		t.Errorf("Expected the download to resume with a fresh signed URL, got ranges %q", ranges)
+	}
+}
@@ -0,0 +1,203 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest"
+	"github.com/opencontainers/go-digest"
+)
+
+// ArtifactDescriptor is a content descriptor carrying the artifactType field
+// introduced by OCI image-spec v1.1.
+type ArtifactDescriptor struct {
+	distribution.Descriptor
+
+	// ArtifactType is the type of artifact the described manifest holds.
+	ArtifactType string `json:"artifactType,omitempty"`
+}
+
+// referrersIndex is the image index returned by the referrers API and stored
+// under the referrers tag by registries which lack it.
+type referrersIndex struct {
+	manifest.Versioned
+
+	Manifests   []ArtifactDescriptor `json:"manifests"`
+	Annotations map[string]string    `json:"annotations,omitempty"`
+}
+
+// ReferrersTag returns the tag under which clients maintain the list of
+// referrers of the given digest on registries without the referrers API,
+// following the OCI distribution spec tag schema: <alg>-<encoded>.
+func ReferrersTag(d digest.Digest) string {
+	tag := fmt.Sprintf("%s-%s", d.Algorithm(), d.Encoded())
+	if len(tag) > 128 {
+		tag = tag[:128]
+	}
+	return tag
+}
+
+// Referrers returns the manifests which refer to the given digest through
+// their subject field, such as signatures, SBOMs and attestations.
+// If artifactType is not empty, only referrers of that type are returned.
+//
+// The OCI referrers API is used where available, following pagination.
+// Registries without it are queried for the index stored under the
+// ReferrersTag of the digest instead. If a later page cannot be fetched, the
+// referrers from the earlier pages are returned together with the error.
+func (registry *Registry) Referrers(repository string, d digest.Digest, artifactType string) ([]ArtifactDescriptor, error) {
+	referrers, _, err := registry.referrers(repository, d, artifactType)
+	return referrers, err
+}
+
+// referrers implements Referrers, additionally reporting whether the registry
+// supports the referrers API.
+func (registry *Registry) referrers(repository string, d digest.Digest, artifactType string) ([]ArtifactDescriptor, bool, error) {
+	if err := d.Validate(); err != nil {
+		return nil, false, fmt.Errorf("invalid digest %v: %w", d, err)
+	}
+
+	referrersUrl := registry.url("/v2/%s/referrers/%s", repository, d)
+	if artifactType != "" {
+		referrersUrl += "?artifactType=" + url.QueryEscape(artifactType)
+	}
+
+	referrers := []ArtifactDescriptor{}
+	entries := 0
+	for page := 1; referrersUrl != ""; page++ {
+		if err := registry.checkPages(page); err != nil {
+			return referrers, true, err
+		}
+		registry.Logf("registry.referrers url=%s repository=%s digest=%s", referrersUrl, repository, d)
+
+		found, filtered, next, err := registry.referrersPage(referrersUrl)
+		if err != nil {
+			// Only a missing first page means the referrers API is unsupported.
+			if page == 1 && isNotFound(err) {
+				fallback, err := registry.referrersFromTag(repository, d)
+				return filterReferrers(fallback, artifactType), false, err
+			}
+			if page == 1 {
+				return nil, true, err
+			}
+			return referrers, true, err
+		}
+		// Bound the total across pages, not only each page.
+		entries += len(found)
+		if err := registry.checkIndexEntries(entries); err != nil {
+			return referrers, true, err
+		}
+		if !filtered {
+			found = filterReferrers(found, artifactType)
+		}
+		referrers = append(referrers, found...)
+
+		// Sometimes only the path is returned instead of the full URL.
+		if strings.HasPrefix(next, "/") {
+			next = registry.URL + next
+		}
+		referrersUrl = next
+	}
+	return referrers, true, nil
+}
+
+// referrersPage fetches one page of the referrers API. It reports whether the
+// registry applied the artifactType filter and returns the URL of the next
+// page, which is empty if there are no more pages.
+func (registry *Registry) referrersPage(referrersUrl string) ([]ArtifactDescriptor, bool, string, error) {
+	req, err := http.NewRequest("GET", referrersUrl, nil)
+	if err != nil {
+		return nil, false, "", err
+	}
+	req.Header.Set("Accept", MediaTypeImageIndex)
+
+	resp, err := registry.Client.Do(req)
+	if err != nil {
+		return nil, false, "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := registry.readManifest(resp.Body)
+	if err != nil {
+		return nil, false, "", err
+	}
+	var index referrersIndex
+	if err := json.Unmarshal(body, &index); err != nil {
+		return nil, false, "", err
+	}
+
+	filtered := false
+	for _, applied := range strings.Split(resp.Header.Get("OCI-Filters-Applied"), ",") {
+		if strings.TrimSpace(applied) == "artifactType" {
+			filtered = true
+		}
+	}
+
+	next, err := getNextLink(resp)
+	if err != nil {
+		next = ""
+	}
+	return index.Manifests, filtered, next, nil
+}
+
+// referrersFromTag reads the referrers index maintained under the referrers
+// tag of the given digest. A missing tag means there are no referrers.
+func (registry *Registry) referrersFromTag(repository string, d digest.Digest) ([]ArtifactDescriptor, error) {
+	index, err := registry.referrersTagIndex(repository, d)
+	if err != nil {
+		return nil, err
+	}
+	if index == nil {
+		return []ArtifactDescriptor{}, nil
+	}
+	return index.Manifests, nil
+}
+
+// referrersTagIndex fetches the index stored under the referrers tag of the
+// given digest, returning nil if there is none.
+func (registry *Registry) referrersTagIndex(repository string, d digest.Digest) (*referrersIndex, error) {
+	url := registry.url("/v2/%s/manifests/%s", repository, ReferrersTag(d))
+	registry.Logf("registry.referrers.tag url=%s repository=%s digest=%s", url, repository, d)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", MediaTypeImageIndex)
+
+	resp, err := registry.Client.Do(req)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := registry.readManifest(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var index referrersIndex
+	if err := json.Unmarshal(body, &index); err != nil {
+		return nil, err
+	}
+	return &index, nil
+}
+
+func filterReferrers(referrers []ArtifactDescriptor, artifactType string) []ArtifactDescriptor {
+	if artifactType == "" {
+		return referrers
+	}
+	filtered := make([]ArtifactDescriptor, 0, len(referrers))
+	for _, referrer := range referrers {
+		if referrer.ArtifactType == artifactType {
+			filtered = append(filtered, referrer)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,116 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestReferrers(t *testing.T) {
+	subject := digest.FromString("subject")
+	brokenSubject := digest.FromString("broken subject")
+	sbom := ArtifactDescriptor{
+		Descriptor:   distribution.Descriptor{MediaType: MediaTypeImageManifest, Digest: digest.FromString("sbom"), Size: 1},
+		ArtifactType: "application/spdx+json",
+	}
+	signature := ArtifactDescriptor{
+		Descriptor:   distribution.Descriptor{MediaType: MediaTypeImageManifest, Digest: digest.FromString("signature"), Size: 1},
+		ArtifactType: "application/vnd.dev.cosign.artifact.sig.v1+json",
+	}
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case fmt.Sprintf("/v2/test/image/referrers/%s", subject):
+			w.Header().Set("Link", `</v2/test/image/referrers/page2>; rel="next"`)
+			_ = json.NewEncoder(w).Encode(referrersIndex{Manifests: []ArtifactDescriptor{sbom}})
+		case fmt.Sprintf("/v2/test/image/referrers/%s", brokenSubject):
+			w.Header().Set("Link", `</v2/test/image/referrers/missing>; rel="next"`)
+			_ = json.NewEncoder(w).Encode(referrersIndex{Manifests: []ArtifactDescriptor{sbom}})
+		case fmt.Sprintf("/v2/test/image/manifests/%s", ReferrersTag(brokenSubject)):
+			t.Error("Expected no referrers tag fallback after the first page")
+			w.WriteHeader(http.StatusNotFound)
+		case "/v2/test/image/referrers/page2":
+			_ = json.NewEncoder(w).Encode(referrersIndex{Manifests: []ArtifactDescriptor{signature}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(s.Close)
+
+	r, err := New(s.URL, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Logf = Quiet
+
+	referrers, err := r.Referrers("test/image", subject, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(referrers) != 2 {
+		t.Fatalf("Expected 2 referrers across pages but got: %d", len(referrers))
+	}
+
+	referrers, err = r.Referrers("test/image", subject, sbom.ArtifactType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(referrers) != 1 || referrers[0].Digest != sbom.Digest {
+		t.Errorf("Expected only the SBOM referrer but got: %+v", referrers)
+	}
+
+	referrers, err = r.Referrers("test/image", brokenSubject, "")
+	if !isNotFound(err) {
+		t.Errorf("Expected the 404 of the second page but got: %v", err)
+	}
+	if len(referrers) != 1 || referrers[0].Digest != sbom.Digest {
+		t.Errorf("Expected the referrers of the first page but got: %+v", referrers)
+	}
+
+	r.Limits.MaxManifestSize = 16
+	var limitErr *LimitExceededError
+	if _, err := r.Referrers("test/image", subject, ""); !errors.As(err, &limitErr) {
+		t.Errorf("Expected a LimitExceededError for a large referrers response but got: %v", err)
+	}
+}
+
+func TestReferrersTagFallback(t *testing.T) {
+	fake, r := newFakeRegistry(t)
+	subject := digest.FromString("subject")
+
+	referrers, err := r.Referrers("test/image", subject, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(referrers) != 0 {
+		t.Fatalf("Expected no referrers but got: %+v", referrers)
+	}
+
+	payload, err := json.Marshal(referrersIndex{Manifests: []ArtifactDescriptor{{
+		Descriptor:   distribution.Descriptor{MediaType: MediaTypeImageManifest, Digest: digest.FromString("sbom"), Size: 1},
+		ArtifactType: "application/spdx+json",
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.manifests[digest.FromBytes(payload)] = fakeManifest{mediaType: MediaTypeImageIndex, payload: payload}
+	fake.tags[ReferrersTag(subject)] = digest.FromBytes(payload)
+
+	referrers, err = r.Referrers("test/image", subject, "application/spdx+json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(referrers) != 1 {
+		t.Errorf("Expected 1 referrer from the fallback tag but got: %+v", referrers)
+	}
+
+	if tag := ReferrersTag(subject); tag != "sha256-"+subject.Encoded() {
+		t.Errorf("Unexpected referrers tag: %q", tag)
+	}
+}
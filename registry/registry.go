@@ -7,6 +7,7 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"strings"
 )
 
@@ -33,10 +34,23 @@ type Transport interface {
 }
 
 type Registry struct {
-	URL       string
+	URL string
+	// Client sends the requests to the registry. The constructors set its
+	// CheckRedirect to a policy which logs redirects, such as those of blob
+	// requests to signed storage URLs. A Registry built as a struct literal
+	// uses the CheckRedirect of the given Client instead; the transports of
+	// this package still leave out credentials when a redirect leaves the
+	// registry host.
 	Client    *http.Client
 	Transport Transport
 	Logf      LogfCallback
+
+	// BlobURLs allows DownloadBlob to fetch blobs from the URLs in their
+	// descriptors when the registry does not serve them. If nil, no such
+	// URLs are used.
+	BlobURLs *URLAllowlist
+	// Limits bounds the size of what is read from the registry.
+	Limits Limits
 }
 
 /*
@@ -99,13 +113,15 @@ func newWithWrapTransport(registryUrl, username, password string, transport http
 
 func NewFromTransport(registryUrl string, transport Transport, logf LogfCallback) (*Registry, error) {
 	registry := &Registry{
-		URL: registryUrl,
-		Client: &http.Client{
-			Transport: transport,
-		},
+		URL:       registryUrl,
 		Transport: transport,
 		Logf:      logf,
 	}
+	registry.Client = &http.Client{
+		Transport:     transport,
+		CheckRedirect: registry.checkRedirect,
+	}
+	useLimits(transport, &registry.Limits)
 
 	return registry, nil
 }
@@ -116,6 +132,25 @@ func (registry *Registry) url(pathTemplate string, args ...interface{}) string {
 	return url
 }
 
+// resolveLocation resolves a URL returned by the registry, such as a Location
+// header, against the URL of the request that resp answers. URLs on the host
+// of the registry keep the scheme of Registry.URL, even if the request was
+// sent over plain HTTP by SchemeFallbackTransport, so that BasicTransport
+// still adds credentials to requests for them.
+func (registry *Registry) resolveLocation(resp *http.Response, location string) (*url.URL, error) {
+	locationUrl, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Request != nil {
+		locationUrl = resp.Request.URL.ResolveReference(locationUrl)
+	}
+	if registryUrl, err := url.Parse(registry.URL); err == nil && strings.EqualFold(locationUrl.Host, registryUrl.Host) {
+		locationUrl.Scheme = registryUrl.Scheme
+	}
+	return locationUrl, nil
+}
+
 func (registry *Registry) Ping() error {
 	url := registry.url("/v2/")
 	registry.Logf("registry.ping url=%s", url)
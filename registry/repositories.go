@@ -11,7 +11,10 @@ func (registry *Registry) Repositories() ([]string, error) {
 	repos := make([]string, 0, 10)
 	var err error //We create this here, otherwise url will be rescoped with :=
 	var response repositoriesResponse
-	for {
+	for page := 1; ; page++ {
+		if err := registry.checkPages(page); err != nil {
+			return nil, err
+		}
 		registry.Logf("registry.repositories url=%s", url)
 		url, err = registry.getPaginatedJson(url, &response)
 		// Sometimes only the path is returned instead of the full URL.
@@ -25,9 +28,15 @@ func (registry *Registry) Repositories() ([]string, error) {
 		switch err {
 		case ErrNoMorePages:
 			repos = append(repos, response.Repositories...)
+			if err := registry.checkListEntries(len(repos)); err != nil {
+				return nil, err
+			}
 			return repos, nil
 		case nil:
 			repos = append(repos, response.Repositories...)
+			if err := registry.checkListEntries(len(repos)); err != nil {
+				return nil, err
+			}
 			continue
 		default:
 			return nil, err
@@ -0,0 +1,274 @@
+package registry
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/opencontainers/go-digest"
+)
+
+// ResumeOptions controls how DownloadBlobResumable retries. Zero fields use
+// the defaults.
+type ResumeOptions struct {
+	// MaxRetries is the number of times a failed request is retried, counted
+	// over the whole download. Defaults to 5.
+	MaxRetries int
+	// InitialBackoff is the delay before the first retry, doubled for each
+	// further one. Defaults to one second.
+	InitialBackoff time.Duration
+	// MaxBackoff bounds the delay between retries. Defaults to 30 seconds.
+	MaxBackoff time.Duration
+	// Context, if set, aborts the download, including any wait before a
+	// retry, once it is done. Closing the returned reader does the same.
+	Context context.Context
+}
+
+func (options ResumeOptions) withDefaults() ResumeOptions {
+	if options.MaxRetries <= 0 {
+		options.MaxRetries = 5
+	}
+	if options.InitialBackoff <= 0 {
+		options.InitialBackoff = time.Second
+	}
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = 30 * time.Second
+	}
+	if options.Context == nil {
+		options.Context = context.Background()
+	}
+	return options
+}
+
+// DownloadBlobResumable downloads the blob described by descriptor from the
+// registry. If the connection fails or the response ends early, the download
+// is resumed where it stopped with a "Range: bytes=N-" request, after a
+// backoff, as long as the registry supports ranges and retries remain. Server
+// errors, rate limiting, timeouts and network failures are retried the same
+// way; other errors, such as certificate errors, are returned at once.
+//
+// The caller sees a single continuous stream, which is verified as with
+// DownloadBlob: if the content does not match the descriptor, the final Read
+// returns an error wrapping ErrDigestMismatch.
+func (registry *Registry) DownloadBlobResumable(repository string, descriptor distribution.Descriptor, options ResumeOptions) (io.ReadCloser, error) {
+	if err := descriptor.Digest.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid blob digest %v: %w", descriptor.Digest, err)
+	}
+
+	r := registry.newResumingReader(repository, descriptor.Digest, descriptor.Size, options)
+	if err := r.open(); err != nil {
+		r.cancel()
+		return nil, err
+	}
+	if descriptor.Size <= 0 {
+		descriptor.Size = r.size
+	}
+	return newVerifyingReader(r, descriptor), nil
+}
+
+// resumingReader reads a blob, reopening it from the current offset when
+// reading fails.
+type resumingReader struct {
+	registry   *Registry
+	repository string
+	digest     digest.Digest
+	// size is the size of the blob, or zero or less if it is unknown.
+	size    int64
+	options ResumeOptions
+	// ctx is cancelled by Close, to abort a Read which is waiting to retry.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	bodyMutex sync.Mutex
+	body      io.ReadCloser
+	offset    int64
+	retries   int
+	// rangesRefused is set when the registry answered with Accept-Ranges: none.
+	rangesRefused bool
+}
+
+func (r *resumingReader) Read(p []byte) (int, error) {
+	for {
+		r.bodyMutex.Lock()
+		body := r.body
+		r.bodyMutex.Unlock()
+
+		n, err := body.Read(p)
+		r.offset += int64(n)
+		if err == io.EOF && (r.size <= 0 || r.offset >= r.size) {
+			return n, io.EOF
+		}
+		if err == nil || n > 0 {
+			return n, nil
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		if r.rangesRefused || r.ctx.Err() != nil {
+			return 0, err
+		}
+		if reopenErr := r.reopen(err); reopenErr != nil {
+			return 0, reopenErr
+		}
+	}
+}
+
+func (r *resumingReader) Close() error {
+	r.cancel()
+	r.bodyMutex.Lock()
+	defer r.bodyMutex.Unlock()
+	return r.body.Close()
+}
+
+func (r *resumingReader) setBody(body io.ReadCloser) {
+	r.bodyMutex.Lock()
+	defer r.bodyMutex.Unlock()
+	r.body = body
+}
+
+func (registry *Registry) newResumingReader(repository string, digest digest.Digest, size int64, options ResumeOptions) *resumingReader {
+	options = options.withDefaults()
+	ctx, cancel := context.WithCancel(options.Context)
+	return &resumingReader{
+		registry:   registry,
+		repository: repository,
+		digest:     digest,
+		size:       size,
+		options:    options,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// open sends the initial request, retrying failures which may be transient.
+func (r *resumingReader) open() error {
+	for {
+		resp, err := r.request(0)
+		if err == nil {
+			if r.size <= 0 {
+				r.size = resp.ContentLength
+			}
+			r.rangesRefused = resp.Header.Get("Accept-Ranges") == "none"
+			r.setBody(resp.Body)
+			return nil
+		}
+		if !isRetryable(err) {
+			return err
+		}
+		if backoffErr := r.backoff(err); backoffErr != nil {
+			return backoffErr
+		}
+	}
+}
+
+// reopen resumes the download at the current offset after cause interrupted it.
+func (r *resumingReader) reopen(cause error) error {
+	r.bodyMutex.Lock()
+	r.body.Close()
+	r.bodyMutex.Unlock()
+	for {
+		if err := r.backoff(cause); err != nil {
+			return err
+		}
+		r.registry.Logf("registry.blob.resume repository=%s digest=%s offset=%d error=%v", r.repository, r.digest, r.offset, cause)
+
+		resp, err := r.request(r.offset)
+		if err != nil {
+			if !isRetryable(err) {
+				return err
+			}
+			cause = err
+			continue
+		}
+		if err := r.checkContentRange(resp); err != nil {
+			resp.Body.Close()
+			return err
+		}
+		r.setBody(resp.Body)
+		return nil
+	}
+}
+
+// checkContentRange verifies that a response to a range request continues
+// the blob at the current offset.
+func (r *resumingReader) checkContentRange(resp *http.Response) error {
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("cannot resume blob %s at %d: %w", r.digest, r.offset, errRangeNotSupported)
+	}
+	start, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+	if !ok || start != r.offset {
+		return fmt.Errorf("cannot resume blob %s at %d: unexpected Content-Range %q", r.digest, r.offset, resp.Header.Get("Content-Range"))
+	}
+	if total >= 0 && r.size > 0 && total != r.size {
+		return fmt.Errorf("%w: blob %s has size %d according to Content-Range, expected %d", ErrSizeMismatch, r.digest, total, r.size)
+	}
+	return nil
+}
+
+func (r *resumingReader) request(offset int64) (*http.Response, error) {
+	var byteRange string
+	if offset > 0 {
+		byteRange = fmt.Sprintf("bytes=%d-", offset)
+	}
+	return r.registry.getBlob(r.ctx, r.repository, r.digest, byteRange)
+}
+
+// backoff waits before the next retry, or returns an error if no retries
+// remain or the download is aborted while waiting.
+func (r *resumingReader) backoff(cause error) error {
+	if r.retries >= r.options.MaxRetries {
+		return fmt.Errorf("giving up on blob %s after %d retries: %w", r.digest, r.retries, cause)
+	}
+	delay := r.options.InitialBackoff << r.retries
+	if delay > r.options.MaxBackoff || delay <= 0 {
+		delay = r.options.MaxBackoff
+	}
+	r.retries++
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-r.ctx.Done():
+		return fmt.Errorf("download of blob %s aborted: %w", r.digest, r.ctx.Err())
+	}
+}
+
+// isRetryable reports whether a failed request may succeed when repeated:
+// server errors, rate limiting, timeouts and network failures. Certificate
+// and TLS errors, invalid requests and cancellation are permanent.
+func isRetryable(err error) bool {
+	if httpErr, ok := httpStatusError(err); ok {
+		status := httpErr.Response.StatusCode
+		return status >= 500 || status == http.StatusTooManyRequests || status == http.StatusRequestTimeout
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		invalidCert      x509.CertificateInvalidError
+		hostnameErr      x509.HostnameError
+		recordErr        tls.RecordHeaderError
+	)
+	if errors.As(err, &unknownAuthority) || errors.As(err, &invalidCert) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &recordErr) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
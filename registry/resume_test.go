@@ -0,0 +1,121 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestDownloadBlobResumable(t *testing.T) {
+	fake, r := newFakeRegistry(t)
+	content := bytes.Repeat([]byte("0123456789abcdef"), 64<<10)
+	descriptor := distribution.Descriptor{Digest: digest.FromBytes(content), Size: int64(len(content))}
+	fake.blobs[descriptor.Digest] = content
+	options := ResumeOptions{MaxRetries: 3, InitialBackoff: time.Millisecond}
+
+	fake.truncateBlobReads = 3
+	blob, err := r.DownloadBlobResumable("test/image", descriptor, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := io.ReadAll(blob)
+	blob.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, content) {
+		t.Errorf("Expected %d bytes of content but got %d different ones", len(content), len(actual))
+	}
+	if fake.fullBlobReads != 1 {
+		t.Errorf("Expected resumed downloads to use range requests, got %d full reads", fake.fullBlobReads)
+	}
+
+	fake.truncateBlobReads = 4
+	blob, err = r.DownloadBlobResumable("test/image", descriptor, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blob.Close()
+	if _, err := io.ReadAll(blob); err == nil {
+		t.Error("Expected an error once the retries are exhausted")
+	}
+
+	tampered := distribution.Descriptor{Digest: digest.FromString("other content"), Size: int64(len(content))}
+	fake.blobs[tampered.Digest] = content
+	fake.truncateBlobReads = 1
+	blob, err = r.DownloadBlobResumable("test/image", tampered, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blob.Close()
+	if _, err := io.ReadAll(blob); !errors.Is(err, ErrDigestMismatch) {
+		t.Errorf("Expected ErrDigestMismatch for resumed content but got: %v", err)
+	}
+}
+
+func TestDownloadBlobResumableCancel(t *testing.T) {
+	fake, r := newFakeRegistry(t)
+	content := bytes.Repeat([]byte("0123456789abcdef"), 64<<10)
+	descriptor := distribution.Descriptor{Digest: digest.FromBytes(content), Size: int64(len(content))}
+	fake.blobs[descriptor.Digest] = content
+	fake.truncateBlobReads = 1
+
+	ctx, cancel := context.WithCancel(context.Background())
+	blob, err := r.DownloadBlobResumable("test/image", descriptor, ResumeOptions{InitialBackoff: time.Hour, Context: ctx})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blob.Close()
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.ReadAll(blob)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected the download to be cancelled but got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Cancelling the context did not interrupt the backoff")
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{"connection refused", &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, true},
+		{"unexpected EOF", io.ErrUnexpectedEOF, true},
+		{"timeout", &url.Error{Op: "Get", Err: timeoutError{}}, true},
+		{"unknown authority", &url.Error{Op: "Get", Err: x509.UnknownAuthorityError{}}, false},
+		{"not TLS", &url.Error{Op: "Get", Err: tls.RecordHeaderError{}}, false},
+		{"cancelled", &url.Error{Op: "Get", Err: context.Canceled}, false},
+		{"bad URL", &url.Error{Op: "parse", Err: errors.New("invalid character")}, false},
+	} {
+		if actual := isRetryable(test.err); actual != test.retryable {
+			t.Errorf("%s: expected retryable %v but got %v", test.name, test.retryable, actual)
+		}
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
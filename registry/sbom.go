@@ -0,0 +1,231 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// MediaTypeSPDXJSON specifies the media type of SPDX documents in JSON.
+	MediaTypeSPDXJSON = "application/spdx+json"
+	// MediaTypeSPDXText specifies the media type of SPDX documents in tag-value format.
+	MediaTypeSPDXText = "text/spdx"
+	// MediaTypeCycloneDXJSON specifies the media type of CycloneDX documents in JSON.
+	MediaTypeCycloneDXJSON = "application/vnd.cyclonedx+json"
+	// MediaTypeCycloneDXXML specifies the media type of CycloneDX documents in XML.
+	MediaTypeCycloneDXXML = "application/vnd.cyclonedx+xml"
+)
+
+// SBOMFormat is the format of an SBOM document.
+type SBOMFormat string
+
+const (
+	SBOMFormatSPDX      SBOMFormat = "spdx"
+	SBOMFormatCycloneDX SBOMFormat = "cyclonedx"
+)
+
+// SBOMSource tells where an SBOM was found.
+type SBOMSource string
+
+const (
+	// SBOMSourceReferrers is an SBOM artifact referring to the image.
+	SBOMSourceReferrers SBOMSource = "referrers"
+	// SBOMSourceCosignTag is an SBOM attached with cosign under the .sbom tag.
+	SBOMSourceCosignTag SBOMSource = "cosign-tag"
+	// SBOMSourceAttestation is an SBOM predicate of an in-toto attestation.
+	SBOMSourceAttestation SBOMSource = "attestation"
+	// SBOMSourceBuildKit is an SBOM predicate of a BuildKit attestation manifest.
+	SBOMSourceBuildKit SBOMSource = "buildkit-attestation"
+)
+
+// SBOM is a software bill of materials published alongside an image.
+type SBOM struct {
+	Format SBOMFormat
+	Source SBOMSource
+	// MediaType of the document. For SBOMs taken from attestations this is
+	// the media type of the layer holding the attestation.
+	MediaType string
+	// Subject is the digest of the image the SBOM describes.
+	Subject digest.Digest
+	// Manifest is the digest of the manifest the SBOM was found in.
+	Manifest digest.Digest
+	// Layer describes the blob the SBOM was read from. Its digest has been verified.
+	Layer distribution.Descriptor
+	// Document is the SBOM itself.
+	Document []byte
+}
+
+// CosignSBOMTag returns the tag cosign attaches the SBOMs of the given digest under.
+func CosignSBOMTag(d digest.Digest) string {
+	return fmt.Sprintf("%s-%s.sbom", d.Algorithm(), d.Encoded())
+}
+
+// SBOMs returns the SPDX and CycloneDX documents published for the given
+// digest as referrers, under the cosign .sbom tag and as SBOM predicates of
+// in-toto attestations. If the digest is that of an index, the SBOMs of its
+// BuildKit attestation manifests are included as well, with the Subject set
+// to the platform specific image they describe. If a source fails, the SBOMs
+// found elsewhere are returned together with the first error.
+func (registry *Registry) SBOMs(repository string, d digest.Digest) ([]SBOM, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid digest %v: %w", d, err)
+	}
+
+	var firstErr error
+	sboms, err := registry.sbomsFromManifest(repository, CosignSBOMTag(d), d, SBOMSourceCosignTag)
+	if err != nil && !isNotFound(err) {
+		firstErr = err
+	}
+
+	referrers, err := registry.Referrers(repository, d, "")
+	if err != nil && firstErr == nil {
+		firstErr = err
+	}
+	for _, referrer := range referrers {
+		if _, ok := sbomFormat(referrer.ArtifactType); !ok {
+			continue
+		}
+		found, err := registry.sbomsFromManifest(repository, referrer.Digest.String(), d, SBOMSourceReferrers)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		sboms = append(sboms, found...)
+	}
+
+	attestations, err := registry.attestations(repository, d, referrers, nil)
+	if err != nil && firstErr == nil {
+		firstErr = err
+	}
+	sboms = append(sboms, sbomsFromAttestations(attestations, d, SBOMSourceAttestation)...)
+
+	found, err := registry.buildKitSBOMs(repository, d)
+	if err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return append(sboms, found...), firstErr
+}
+
+// buildKitSBOMs returns the SBOMs of the BuildKit attestation manifests of
+// the index with the given digest, or none if it is not an index.
+func (registry *Registry) buildKitSBOMs(repository string, d digest.Digest) ([]SBOM, error) {
+	payload, contentType, err := registry.fetchManifest(repository, d.String(), manifestMediaTypes...)
+	if err != nil {
+		return nil, err
+	}
+	if !isIndexMediaType(contentType) {
+		return nil, nil
+	}
+	index, err := registry.decodeIndex(d.String(), payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var sboms []SBOM
+	var firstErr error
+	for _, manifest := range indexAttestationManifests(index.Manifests) {
+		found, err := registry.BuildKitAttestations(repository, manifest)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		sboms = append(sboms, sbomsFromAttestations(found, manifest.Subject, SBOMSourceBuildKit)...)
+	}
+	return sboms, firstErr
+}
+
+// sbomsFromManifest downloads the layers of a manifest which hold SBOM
+// documents. A layer which cannot be downloaded is skipped, and the first
+// such error returned along with the other SBOMs.
+func (registry *Registry) sbomsFromManifest(repository, reference string, subject digest.Digest, source SBOMSource) ([]SBOM, error) {
+	payload, _, err := registry.fetchManifest(repository, reference, MediaTypeImageManifest, schema2.MediaTypeManifest)
+	if err != nil {
+		return nil, err
+	}
+	var document manifestDocument
+	if err := json.Unmarshal(payload, &document); err != nil {
+		return nil, err
+	}
+
+	manifestDigest := digest.FromBytes(payload)
+	var sboms []SBOM
+	var firstErr error
+	for _, layer := range document.Layers {
+		format, ok := sbomFormat(layer.MediaType)
+		if !ok {
+			continue
+		}
+		content, err := registry.fetchBlob(repository, layer)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		sboms = append(sboms, SBOM{
+			Format:    format,
+			Source:    source,
+			MediaType: layer.MediaType,
+			Subject:   subject,
+			Manifest:  manifestDigest,
+			Layer:     layer,
+			Document:  content,
+		})
+	}
+	return sboms, firstErr
+}
+
+func sbomsFromAttestations(attestations []Attestation, subject digest.Digest, source SBOMSource) []SBOM {
+	var sboms []SBOM
+	for _, attestation := range attestations {
+		format, ok := sbomPredicateFormat(attestation.Statement.PredicateType)
+		if !ok {
+			continue
+		}
+		sboms = append(sboms, SBOM{
+			Format:    format,
+			Source:    source,
+			MediaType: attestation.Layer.MediaType,
+			Subject:   subject,
+			Manifest:  attestation.Manifest,
+			Layer:     attestation.Layer,
+			Document:  attestation.Statement.Predicate,
+		})
+	}
+	return sboms
+}
+
+// sbomFormat returns the SBOM format of a media type, ignoring any parameters.
+func sbomFormat(mediaType string) (SBOMFormat, bool) {
+	switch strings.TrimSpace(strings.Split(mediaType, ";")[0]) {
+	case MediaTypeSPDXJSON, MediaTypeSPDXText, "text/spdx+json":
+		return SBOMFormatSPDX, true
+	case MediaTypeCycloneDXJSON, MediaTypeCycloneDXXML:
+		return SBOMFormatCycloneDX, true
+	}
+	return "", false
+}
+
+// sbomPredicateFormat returns the SBOM format of an in-toto predicate type,
+// accepting versioned variants such as https://spdx.dev/Document/v2.3.
+func sbomPredicateFormat(predicateType string) (SBOMFormat, bool) {
+	switch {
+	case strings.HasPrefix(predicateType, PredicateTypeSPDX):
+		return SBOMFormatSPDX, true
+	case strings.HasPrefix(predicateType, PredicateTypeCycloneDX):
+		return SBOMFormatCycloneDX, true
+	}
+	return "", false
+}
+
+func isIndexMediaType(contentType string) bool {
+	switch strings.TrimSpace(strings.Split(contentType, ";")[0]) {
+	case MediaTypeImageIndex, manifestlist.MediaTypeManifestList:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package registry
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSBOMs(t *testing.T) {
+	fake, r := newFakeRegistry(t)
+	image := pushTestImage(t, r, "test/image", `{"architecture":"amd64","os":"linux"}`)
+	subject := fake.descriptor(image)
+
+	if _, err := r.PushArtifact("test/image", "", Artifact{
+		ArtifactType: MediaTypeSPDXJSON,
+		Blobs:        []ArtifactBlob{{MediaType: MediaTypeSPDXJSON, Content: []byte(`{"spdxVersion":"SPDX-2.3"}`)}},
+		Subject:      &subject,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.PushArtifact("test/image", CosignSBOMTag(image), Artifact{
+		ConfigMediaType: MediaTypeImageConfig,
+		Config:          []byte(`{}`),
+		Blobs:           []ArtifactBlob{{MediaType: MediaTypeCycloneDXJSON, Content: []byte(`{"bomFormat":"CycloneDX"}`)}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	sboms, err := r.SBOMs("test/image", image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sboms) != 2 {
+		t.Fatalf("Expected 2 SBOMs but got: %d", len(sboms))
+	}
+
+	found := make(map[SBOMSource]SBOMFormat)
+	for _, sbom := range sboms {
+		found[sbom.Source] = sbom.Format
+		if sbom.Subject != image {
+			t.Errorf("Expected subject %s but got: %s", image, sbom.Subject)
+		}
+	}
+	if found[SBOMSourceReferrers] != SBOMFormatSPDX {
+		t.Errorf("Expected an SPDX SBOM from the referrers but got: %v", found)
+	}
+	if found[SBOMSourceCosignTag] != SBOMFormatCycloneDX {
+		t.Errorf("Expected a CycloneDX SBOM from the cosign tag but got: %v", found)
+	}
+}
+
+func TestSBOMsFromAttestations(t *testing.T) {
+	fake, r := newFakeRegistry(t)
+	amd64 := pushTestImage(t, r, "test/image", `{"architecture":"amd64","os":"linux"}`)
+
+	if _, err := r.PushArtifact("test/image", CosignAttestationTag(amd64), Artifact{
+		ConfigMediaType: MediaTypeImageConfig,
+		Config:          []byte(`{}`),
+		Blobs: []ArtifactBlob{
+			{MediaType: MediaTypeInToto, Content: inTotoStatement(amd64, PredicateTypeSPDX, `{"spdxVersion":"SPDX-2.3"}`)},
+			{MediaType: MediaTypeInToto, Content: inTotoStatement(amd64, PredicateTypeCycloneDX, `{"bomFormat":"CycloneDX"}`)},
+			{MediaType: MediaTypeInToto, Content: inTotoStatement(amd64, PredicateTypeSLSAProvenanceV02, `{}`)},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	sboms, err := r.SBOMs("test/image", amd64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	formats := make(map[SBOMFormat]bool)
+	for _, sbom := range sboms {
+		if sbom.Source != SBOMSourceAttestation || sbom.Subject != amd64 {
+			t.Errorf("Expected an attestation SBOM of %s but got: %+v", amd64, sbom)
+		}
+		formats[sbom.Format] = true
+	}
+	if len(sboms) != 2 || !formats[SBOMFormatSPDX] || !formats[SBOMFormatCycloneDX] {
+		t.Errorf("Expected an SPDX and a CycloneDX SBOM but got: %+v", sboms)
+	}
+	if fake.referrersRequests != 1 {
+		t.Errorf("Expected the referrers to be listed once but got %d requests", fake.referrersRequests)
+	}
+
+	fake.referrersStatus = http.StatusInternalServerError
+	sboms, err = r.SBOMs("test/image", amd64)
+	if err == nil {
+		t.Error("Expected the referrers error to be returned")
+	}
+	if len(sboms) != 2 {
+		t.Errorf("Expected the attestation SBOMs despite the referrers error but got: %d", len(sboms))
+	}
+}
+
+func TestSBOMsFromBuildKitAttestations(t *testing.T) {
+	_, r := newFakeRegistry(t)
+	amd64 := pushTestImage(t, r, "test/image", `{"architecture":"amd64","os":"linux"}`)
+
+	index, err := r.PushIndex("test/image", "latest", MediaTypeImageIndex, []IndexEntry{
+		{Digest: amd64},
+		pushAttestationManifest(t, r, amd64,
+			inTotoStatement(amd64, PredicateTypeSPDX, `{"spdxVersion":"SPDX-2.3"}`),
+			inTotoStatement(amd64, PredicateTypeSLSAProvenanceV02, `{}`)),
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sboms, err := r.SBOMs("test/image", index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sboms) != 1 {
+		t.Fatalf("Expected 1 SBOM but got: %d", len(sboms))
+	}
+	if sboms[0].Source != SBOMSourceBuildKit || sboms[0].Format != SBOMFormatSPDX || sboms[0].Subject != amd64 {
+		t.Errorf("Expected the SPDX SBOM of %s from the BuildKit attestations but got: %+v", amd64, sboms[0])
+	}
+	if string(sboms[0].Document) != `{"spdxVersion":"SPDX-2.3"}` {
+		t.Errorf("Unexpected SBOM document: %s", sboms[0].Document)
+	}
+}
@@ -0,0 +1,200 @@
+package registry
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/opencontainers/go-digest"
+)
+
+// SignOptions controls how SignImage creates a signature.
+type SignOptions struct {
+	// DockerReference is recorded as critical.identity.docker-reference in
+	// the payload. It defaults to the registry host and the repository.
+	DockerReference string
+	// Annotations are recorded in the optional section of the payload.
+	Annotations map[string]interface{}
+	// Referrers additionally pushes the signature as an OCI referrer of the
+	// image, for clients which look for signatures through the referrers API.
+	Referrers bool
+}
+
+// SignImage signs the image with the given digest the way cosign does: it
+// creates a simple signing payload for the digest, signs it with signer and
+// adds it as a layer to the signature manifest stored under the
+// CosignSignatureTag of the image. Existing signatures in that manifest are
+// kept. ECDSA and RSA signers sign the SHA-256 digest of the payload, ed25519
+// signers the payload itself.
+//
+// The signature manifest is updated with a read-modify-write cycle, so
+// concurrent signers of the same image may overwrite each other's signatures.
+func (registry *Registry) SignImage(repository string, d digest.Digest, signer crypto.Signer, options SignOptions) (PushedManifest, error) {
+	subjectPayload, subjectMediaType, err := registry.fetchManifest(repository, d.String(), manifestMediaTypes...)
+	if err != nil {
+		return PushedManifest{}, err
+	}
+	if actual := d.Algorithm().FromBytes(subjectPayload); actual != d {
+		return PushedManifest{}, fmt.Errorf("%w: manifest %s has digest %s", ErrDigestMismatch, d, actual)
+	}
+
+	dockerReference := options.DockerReference
+	if dockerReference == "" {
+		dockerReference, err = registry.dockerReference(repository)
+		if err != nil {
+			return PushedManifest{}, err
+		}
+	}
+	payload, err := simpleSigningPayload(dockerReference, d, options.Annotations)
+	if err != nil {
+		return PushedManifest{}, err
+	}
+	signature, err := signPayload(signer, payload)
+	if err != nil {
+		return PushedManifest{}, err
+	}
+
+	layer := distribution.Descriptor{
+		MediaType: MediaTypeCosignSimpleSigning,
+		Size:      int64(len(payload)),
+		Digest:    digest.FromBytes(payload),
+		Annotations: map[string]string{
+			AnnotationCosignSignature: base64.StdEncoding.EncodeToString(signature),
+		},
+	}
+	if err := registry.uploadBlobIfMissing(repository, layer.Digest, payload); err != nil {
+		return PushedManifest{}, err
+	}
+
+	layers, err := registry.existingSignatureLayers(repository, d)
+	if err != nil {
+		return PushedManifest{}, err
+	}
+	layers = append(layers, layer)
+
+	pushed, err := registry.pushSignatureManifest(repository, CosignSignatureTag(d), layers, nil, "")
+	if err != nil || !options.Referrers {
+		return pushed, err
+	}
+
+	subject := &distribution.Descriptor{
+		MediaType: strings.TrimSpace(strings.Split(subjectMediaType, ";")[0]),
+		Size:      int64(len(subjectPayload)),
+		Digest:    d,
+	}
+	_, err = registry.pushSignatureManifest(repository, "", []distribution.Descriptor{layer}, subject, ArtifactTypeCosignSignature)
+	return pushed, err
+}
+
+// dockerReference returns the registry host and repository, as cosign records
+// them in signature payloads.
+func (registry *Registry) dockerReference(repository string) (string, error) {
+	registryUrl, err := url.Parse(registry.URL)
+	if err != nil {
+		return "", err
+	}
+	return registryUrl.Host + "/" + repository, nil
+}
+
+func simpleSigningPayload(dockerReference string, d digest.Digest, annotations map[string]interface{}) ([]byte, error) {
+	var payload SimpleSigningPayload
+	payload.Critical.Identity.DockerReference = dockerReference
+	payload.Critical.Image.DockerManifestDigest = d
+	payload.Critical.Type = cosignSignatureType
+	payload.Optional = annotations
+	return json.Marshal(payload)
+}
+
+func signPayload(signer crypto.Signer, payload []byte) ([]byte, error) {
+	if _, ok := signer.Public().(ed25519.PublicKey); ok {
+		return signer.Sign(rand.Reader, payload, crypto.Hash(0))
+	}
+	hashed := sha256.Sum256(payload)
+	return signer.Sign(rand.Reader, hashed[:], crypto.SHA256)
+}
+
+// existingSignatureLayers returns the layers of the signature manifest under
+// the cosign signature tag of the given digest, if there is one.
+func (registry *Registry) existingSignatureLayers(repository string, d digest.Digest) ([]distribution.Descriptor, error) {
+	payload, _, err := registry.fetchManifest(repository, CosignSignatureTag(d), MediaTypeImageManifest, schema2.MediaTypeManifest)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var document manifestDocument
+	if err := json.Unmarshal(payload, &document); err != nil {
+		return nil, err
+	}
+	return document.Layers, nil
+}
+
+// pushSignatureManifest pushes a cosign compatible signature manifest holding
+// the given payload layers. Layers with the same payload and signature are
+// only included once.
+func (registry *Registry) pushSignatureManifest(repository, reference string, layers []distribution.Descriptor, subject *distribution.Descriptor, artifactType string) (PushedManifest, error) {
+	var unique []distribution.Descriptor
+	seen := make(map[string]bool)
+	for _, layer := range layers {
+		key := layer.Digest.String() + layer.Annotations[AnnotationCosignSignature]
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, layer)
+		}
+	}
+
+	config, err := signatureConfig(unique)
+	if err != nil {
+		return PushedManifest{}, err
+	}
+	configDescriptor := distribution.Descriptor{
+		MediaType: MediaTypeImageConfig,
+		Size:      int64(len(config)),
+		Digest:    digest.FromBytes(config),
+	}
+	if err := registry.uploadBlobIfMissing(repository, configDescriptor.Digest, config); err != nil {
+		return PushedManifest{}, err
+	}
+
+	m, err := ArtifactManifestFromStruct(ArtifactManifest{
+		Versioned: manifest.Versioned{
+			SchemaVersion: 2,
+			MediaType:     MediaTypeImageManifest,
+		},
+		ArtifactType: artifactType,
+		Config:       configDescriptor,
+		Layers:       unique,
+		Subject:      subject,
+	})
+	if err != nil {
+		return PushedManifest{}, err
+	}
+	return registry.PushArtifactManifest(repository, reference, m)
+}
+
+// signatureConfig returns an image config listing the payload layers, as
+// cosign writes for its signature manifests.
+func signatureConfig(layers []distribution.Descriptor) ([]byte, error) {
+	diffIDs := make([]digest.Digest, len(layers))
+	for i, layer := range layers {
+		diffIDs[i] = layer.Digest
+	}
+	return json.Marshal(struct {
+		Architecture string       `json:"architecture"`
+		OS           string       `json:"os"`
+		Config       struct{}     `json:"config"`
+		RootFS       configRootFS `json:"rootfs"`
+	}{
+		RootFS: configRootFS{Type: "layers", DiffIDs: diffIDs},
+	})
+}
@@ -0,0 +1,55 @@
+package registry
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"strings"
+	"testing"
+)
+
+func TestSignImage(t *testing.T) {
+	_, r := newFakeRegistry(t)
+	image := pushTestImage(t, r, "test/image", `{"architecture":"amd64","os":"linux"}`)
+
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.SignImage("test/image", image, ecdsaKey, SignOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.SignImage("test/image", image, ed25519Key, SignOptions{Referrers: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	signatures, err := r.Signatures("test/image", image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signatures) != 2 {
+		t.Fatalf("Expected 2 signatures but got: %d", len(signatures))
+	}
+	if ref := signatures[0].SimpleSigning.Critical.Identity.DockerReference; !strings.HasSuffix(ref, "/test/image") {
+		t.Errorf("Unexpected docker-reference: %q", ref)
+	}
+
+	verified := VerifiedSignatures(signatures, image, &ecdsaKey.PublicKey, ed25519Key.Public())
+	if len(verified) != 2 {
+		t.Errorf("Expected both signatures to verify but got: %d", len(verified))
+	}
+
+	referrers, err := r.Referrers("test/image", image, ArtifactTypeCosignSignature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(referrers) != 1 {
+		t.Errorf("Expected the ed25519 signature to be recorded as a referrer but got: %+v", referrers)
+	}
+}
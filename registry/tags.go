@@ -8,15 +8,21 @@ func (registry *Registry) Tags(repository string) (tags []string, err error) {
 	url := registry.url("/v2/%s/tags/list", repository)
 
 	var response tagsResponse
-	for {
+	for page := 1; ; page++ {
+		if err := registry.checkPages(page); err != nil {
+			return nil, err
+		}
 		registry.Logf("registry.tags url=%s repository=%s", url, repository)
 		url, err = registry.getPaginatedJson(url, &response)
 		switch err {
 		case ErrNoMorePages:
 			tags = append(tags, response.Tags...)
-			return tags, nil
+			return tags, registry.checkListEntries(len(tags))
 		case nil:
 			tags = append(tags, response.Tags...)
+			if err := registry.checkListEntries(len(tags)); err != nil {
+				return nil, err
+			}
 			continue
 		default:
 			return nil, err
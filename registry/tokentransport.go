@@ -12,6 +12,11 @@ type TokenTransport struct {
 	Transport http.RoundTripper
 	Username  string
 	Password  string
+	// MaxTokenSize bounds the size of token responses. Defaults to the
+	// MaxTokenSize of the registry's Limits.
+	MaxTokenSize int64
+
+	limits *Limits
 
 	token      string
 	tokenMutex sync.RWMutex
@@ -22,6 +27,10 @@ func (t *TokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	if err != nil {
 		return resp, err
 	}
+	// Never authenticate to a host a registry request was redirected to.
+	if redirectedAcrossHosts(req) {
+		return resp, err
+	}
 	if authService := isTokenDemand(resp); authService != nil {
 		if resp != nil && resp.Body != nil {
 			_ = resp.Body.Close()
@@ -76,12 +85,14 @@ func (t *TokenTransport) auth(authService *authService) (string, *http.Response,
 	}
 	defer response.Body.Close()
 
-	var authToken authToken
-	decoder := json.NewDecoder(response.Body)
-	err = decoder.Decode(&authToken)
+	body, err := readLimited(response.Body, limitOrDefault(t.MaxTokenSize, t.limits.maxTokenSize()), "token response")
 	if err != nil {
 		return "", nil, err
 	}
+	var authToken authToken
+	if err := json.Unmarshal(body, &authToken); err != nil {
+		return "", nil, err
+	}
 
 	t.tokenMutex.Lock()
 	defer t.tokenMutex.Unlock()